@@ -6,27 +6,38 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"fmt"
+	"github.com/zlx2019/kinx/kclient"
 	"github.com/zlx2019/kinx/knet"
-	"net"
 	"os"
 	"strings"
+	"time"
 )
 
 // 阻塞式TCP服务 - 客户端
 func main() {
 	// 连接服务端
-	conn, _ := net.Dial("tcp", "127.0.0.1:9780")
-	// 打包器
-	pack := knet.NewNormalPacker()
+	client, err := kclient.Dial("127.0.0.1:9780")
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
 	stdin := bufio.NewReader(os.Stdin)
 	var msgId uint64 = 0
 	for {
 		line, _, _ := stdin.ReadLine()
 		if strings.Contains(string(line), "quit") {
-			_ = conn.Close()
 			break
 		}
-		p, _ := pack.Pack(knet.NewMessage(msgId, line))
-		_, _ = conn.Write(p)
+		// 发送请求，并且等待服务端的响应
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		reply, err := client.Call(ctx, knet.NewMessage(msgId, line))
+		cancel()
+		if err != nil {
+			fmt.Println("call failed cause: ", err.Error())
+			continue
+		}
+		fmt.Printf("reply: %s \n", string(reply.Payload()))
 	}
 }
@@ -10,7 +10,10 @@ import (
 	"github.com/zlx2019/kinx/kiface"
 	"github.com/zlx2019/kinx/knet"
 	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -24,7 +27,18 @@ func main() {
 		// 设置连接空闲超时时间
 		knet.WithIdleTimeout(time.Hour*30),
 		// 设置处理器
-		knet.WithHandler(&CustomHandler{}))
+		knet.WithHandler(&CustomHandler{}),
+		// 设置服务关闭时的告别消息
+		knet.WithGoodbye(knet.NewMessage(knet.MsgIDGoodbye, []byte("server shutdown"))))
+	// 监听退出信号，优雅关闭服务
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		_ = s.Shutdown(ctx)
+	}()
 	// 启动服务
 	err := s.Run()
 	if err != nil {
@@ -49,8 +63,8 @@ func (c *CustomHandler) OnHandler(ctx kiface.IHandlerContext) error {
 	msg := fmt.Sprintf("[%s]: %s", ctx.GetSession().GetRemoteAddr(), string(message.Payload()))
 	fmt.Println(msg)
 
-	// 写回消息
-	_ = ctx.GetSession().Write(message)
+	// 响应消息
+	_ = ctx.Reply(message.Payload())
 
 	// 关闭会话
 	if strings.Contains(msg, "stop") {
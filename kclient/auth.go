@@ -0,0 +1,105 @@
+// @Title auth.go
+// @Description 客户端认证，与服务端内置的认证器对应
+// @Author Zero - 2023/10/11 14:21:50
+
+package kclient
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"github.com/zlx2019/kinx/knet"
+	"net"
+	"time"
+)
+
+// 认证过程中单次读取消息的超时时间
+const authReadTimeout = time.Second * 5
+
+// AuthFunc 认证函数，通过 AuthConn 与服务端进行消息交互
+type AuthFunc func(conn *AuthConn) error
+
+// AuthConn 认证阶段的连接，在读写协程启动前同步地读写消息
+type AuthConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	packer kiface.IPacker
+}
+
+// Write 向服务端写入消息
+func (ac *AuthConn) Write(message kiface.IMessage) error {
+	pack, err := ac.packer.Pack(message)
+	if err != nil {
+		return err
+	}
+	_, err = ac.conn.Write(pack)
+	knet.ReleasePack(ac.packer, pack)
+	return err
+}
+
+// Read 读取服务端的消息
+func (ac *AuthConn) Read(timeout time.Duration) (kiface.IMessage, error) {
+	_ = ac.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer ac.conn.SetReadDeadline(time.Time{})
+	return ac.packer.UnPack(ac.reader)
+}
+
+// TokenAuth 令牌认证，对应服务端的 knet.NewTokenAuthenticator
+func TokenAuth(token string) AuthFunc {
+	return func(conn *AuthConn) error {
+		if err := conn.Write(knet.NewMessage(knet.MsgIDAuth, []byte(token))); err != nil {
+			return err
+		}
+		return readAuthResult(conn)
+	}
+}
+
+// PasswordAuth 用户名密码认证，对应服务端的 knet.NewPasswordAuthenticator
+func PasswordAuth(username, password string) AuthFunc {
+	return func(conn *AuthConn) error {
+		if err := conn.Write(knet.NewMessage(knet.MsgIDAuth, []byte(username+":"+password))); err != nil {
+			return err
+		}
+		return readAuthResult(conn)
+	}
+}
+
+// HMACAuth HMAC 挑战/应答认证，对应服务端的 knet.NewHMACAuthenticator
+func HMACAuth(clientID string, secret []byte) AuthFunc {
+	return func(conn *AuthConn) error {
+		if err := conn.Write(knet.NewMessage(knet.MsgIDAuth, []byte(clientID))); err != nil {
+			return err
+		}
+		challenge, err := conn.Read(authReadTimeout)
+		if err != nil {
+			return err
+		}
+		if challenge.ID() != knet.MsgIDAuthChallenge {
+			return authError(challenge)
+		}
+		if err = conn.Write(knet.NewMessage(knet.MsgIDAuth, knet.SignChallenge(secret, challenge.Payload()))); err != nil {
+			return err
+		}
+		return readAuthResult(conn)
+	}
+}
+
+// readAuthResult 读取服务端的认证结果
+func readAuthResult(conn *AuthConn) error {
+	result, err := conn.Read(authReadTimeout)
+	if err != nil {
+		return err
+	}
+	if result.ID() != knet.MsgIDAuthOK {
+		return authError(result)
+	}
+	return nil
+}
+
+// authError 根据服务端的响应构建认证失败错误
+func authError(message kiface.IMessage) error {
+	if message.ID() == knet.MsgIDAuthFailed {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, string(message.Payload()))
+	}
+	return fmt.Errorf("%w: unexpected message ID %d", ErrAuthFailed, message.ID())
+}
@@ -0,0 +1,36 @@
+// @Title backoff.go
+// @Description 重连的指数退避策略
+// @Author Zero - 2023/9/28 11:20:37
+
+package kclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// 默认的最小重连间隔
+	defaultBackoffMin = time.Millisecond * 100
+	// 默认的最大重连间隔
+	defaultBackoffMax = time.Second * 30
+)
+
+// backoff 带随机抖动的指数退避策略
+// 第 n 次重连的间隔为 min * 2^n，不超过 max，并在 [间隔/2, 间隔] 范围内随机抖动，避免大量客户端同时重连
+type backoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+// next 计算第 attempt 次(从0开始)重连前的等待时间
+func (b backoff) next(attempt int) time.Duration {
+	delay := b.max
+	if attempt < 32 {
+		if d := b.min << uint(attempt); d > 0 && d < b.max {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,468 @@
+// @Title client.go
+// @Description kinx 客户端，支持单向发送与请求/响应模式
+// @Author Zero - 2023/9/27 15:40:26
+
+package kclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"github.com/zlx2019/kinx/knet"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 默认建立连接的超时时间
+	defaultDialTimeout = time.Second * 5
+	// 默认发送队列的容量
+	defaultQueueSize = 16
+)
+
+// Client kinx 客户端
+// 与 knet.NormalSession 相同，由读、写两个协程分别负责读取连接数据与发送消息;
+// Call 请求会为消息分配一个序列号，服务端响应时携带相同的序列号，由此将响应与请求关联.
+// 开启自动重连后，连接断开期间发送的消息暂存于发送队列中，重连成功后继续发送.
+type Client struct {
+	// 网络类型: "tcp" | "unix"
+	network string
+	// 服务端地址，network 为 "unix" 时为套接字文件路径
+	address string
+	// WebSocket 请求路径，设置后通过 WebSocket 协议连接服务端
+	webSocketPath string
+	// 是否通过 WebSocket 协议连接服务端
+	isWebSocket bool
+	// 客户端连接，断开期间为nil
+	conn net.Conn
+	// 连接可用信号，连接建立后关闭该通道，连接断开后重新创建
+	connected chan struct{}
+	// 消息封包与解包处理器
+	packer kiface.IPacker
+	// 建立连接的超时时间
+	dialTimeout time.Duration
+	// TLS配置，为nil时使用明文传输
+	tlsConfig *tls.Config
+	// 认证函数，每次建立连接后执行
+	auth AuthFunc
+	// 发送队列的容量
+	queueSize int
+	// 服务端推送消息的处理函数
+	onMessage func(kiface.IMessage)
+
+	// 是否开启断线自动重连
+	reconnect bool
+	// 重连退避策略
+	backoff backoff
+	// 最大连续重连次数，0 表示不限制
+	maxRetries int
+	// 连接断开的回调函数
+	onDisconnect func(error)
+	// 重连成功的回调函数
+	onReconnect func()
+
+	// 心跳间隔，0 表示不开启心跳
+	heartbeatInterval time.Duration
+	// 连续未收到消息的最大心跳次数，超过后断开连接
+	maxMissedBeats int
+	// 本次心跳间隔内是否收到过服务端的消息
+	received atomic.Bool
+
+	// 下一个请求序列号，采用自增策略
+	nextSeq uint64
+	// 等待响应的请求，key为请求序列号
+	pending map[uint64]chan kiface.IMessage
+	// pending、conn 以及 connected 的互斥锁
+	mu sync.Mutex
+
+	// 消息输出通道，由写协程读取并且发送给服务端；断线期间作为有界的待发送缓冲区
+	outChannel chan kiface.IMessage
+	// 客户端关闭信号
+	done chan struct{}
+	// 保证只关闭一次
+	closeOnce sync.Once
+}
+
+// Dial 连接服务端，并且启动客户端的读写协程
+func Dial(address string, opts ...Option) (*Client, error) {
+	client := &Client{
+		network:     "tcp",
+		address:     address,
+		packer:      knet.NewNormalPacker(),
+		dialTimeout: defaultDialTimeout,
+		queueSize:   defaultQueueSize,
+		backoff:     backoff{min: defaultBackoffMin, max: defaultBackoffMax},
+		pending:     make(map[uint64]chan kiface.IMessage),
+		connected:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	conn, reader, err := client.connect()
+	if err != nil {
+		return nil, err
+	}
+	client.outChannel = make(chan kiface.IMessage, client.queueSize)
+	_ = client.setConn(conn, reader)
+	go client.writer()
+	return client, nil
+}
+
+// Send 将消息添加至发送队列，不等待服务端响应
+// 连接断开期间发送队列已满时，立即返回 ErrQueueFull
+func (c *Client) Send(message kiface.IMessage) error {
+	// 先检查关闭信号，避免客户端关闭后发送队列仍有空位时消息被静默丢弃
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+	select {
+	case c.outChannel <- message:
+		return nil
+	default:
+	}
+	if !c.IsConnected() {
+		return ErrQueueFull
+	}
+	select {
+	case c.outChannel <- message:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+// Call 发送请求消息，并且阻塞等待服务端携带相同序列号的响应消息
+// ctx 用于控制等待超时以及取消；请求在连接断开时若已发出，响应将会丢失，因此 ctx 应当设置超时时间
+// 服务端以 ReplyError 响应时返回 *knet.ErrorReply 错误；服务端未匹配到路由时返回 ErrUnknownRoute
+func (c *Client) Call(ctx context.Context, message kiface.IMessage) (kiface.IMessage, error) {
+	seq := c.allocSeq()
+	message.PutSeq(seq)
+	// 注册等待的请求
+	reply := make(chan kiface.IMessage, 1)
+	c.mu.Lock()
+	c.pending[seq] = reply
+	c.mu.Unlock()
+	defer c.removePending(seq)
+
+	if err := c.Send(message); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-reply:
+		// 服务端通过 ReplyError 响应的错误
+		if e, ok := knet.ParseErrorReply(resp); ok {
+			return nil, e
+		}
+		if resp.ID() == knet.MsgIDUnknownRoute {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRoute, string(resp.Payload()))
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClientClosed
+	}
+}
+
+// Close 关闭客户端，不再进行重连
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+	return err
+}
+
+// Done 返回客户端关闭信号
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// IsConnected 客户端当前是否处于连接状态
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// LocalAddr 获取客户端连接的本地地址，连接断开期间返回nil
+func (c *Client) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.LocalAddr()
+}
+
+// dial 建立与服务端的连接
+func (c *Client) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	if c.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, c.network, c.address, c.tlsConfig)
+	}
+	return dialer.Dial(c.network, c.address)
+}
+
+// connect 建立与服务端的连接，并且完成认证
+func (c *Client) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.isWebSocket {
+		ws, err := knet.NewWebSocketClient(conn, c.address, c.webSocketPath, c.dialTimeout)
+		if err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+		conn = ws
+	}
+	reader := bufio.NewReader(conn)
+	if c.auth != nil {
+		if err = c.auth(&AuthConn{conn: conn, reader: reader, packer: c.packer}); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, reader, nil
+}
+
+// setConn 设置可用的连接，启动该连接的读协程，并且通知写协程连接可用
+// 客户端已关闭时关闭该连接并返回false
+func (c *Client) setConn(conn net.Conn, reader *bufio.Reader) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		_ = conn.Close()
+		return false
+	default:
+	}
+	c.conn = conn
+	close(c.connected)
+	go c.reader(conn, reader)
+	if c.heartbeatInterval > 0 {
+		c.received.Store(true)
+		go c.heartbeat(conn)
+	}
+	return true
+}
+
+// waitConn 阻塞等待可用的连接，客户端关闭时返回nil
+func (c *Client) waitConn() net.Conn {
+	for {
+		c.mu.Lock()
+		conn, connected := c.conn, c.connected
+		c.mu.Unlock()
+		if conn != nil {
+			return conn
+		}
+		select {
+		case <-connected:
+		case <-c.done:
+			return nil
+		}
+	}
+}
+
+// disconnect 处理连接断开，开启自动重连时启动重连协程，否则关闭客户端
+func (c *Client) disconnect(conn net.Conn, cause error) {
+	c.mu.Lock()
+	if c.conn != conn {
+		// 该连接的断开已被处理
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.connected = make(chan struct{})
+	c.mu.Unlock()
+	_ = conn.Close()
+
+	select {
+	case <-c.done:
+		// 客户端主动关闭
+		return
+	default:
+	}
+	if c.onDisconnect != nil {
+		c.onDisconnect(cause)
+	}
+	if !c.reconnect {
+		_ = c.Close()
+		return
+	}
+	go c.reconnectLoop()
+}
+
+// reconnectLoop 按照退避策略不断重连，直到成功、超过最大重连次数或者客户端关闭
+func (c *Client) reconnectLoop() {
+	for attempt := 0; c.maxRetries == 0 || attempt < c.maxRetries; attempt++ {
+		select {
+		case <-time.After(c.backoff.next(attempt)):
+		case <-c.done:
+			return
+		}
+		conn, reader, err := c.connect()
+		if err != nil {
+			continue
+		}
+		if !c.setConn(conn, reader) {
+			// 重连期间客户端已关闭
+			return
+		}
+		if c.onReconnect != nil {
+			c.onReconnect()
+		}
+		return
+	}
+	// 超过最大重连次数
+	_ = c.Close()
+}
+
+// reader 读协程，读取服务端的消息，分发给等待的请求或推送消息处理函数
+func (c *Client) reader(conn net.Conn, reader *bufio.Reader) {
+	for {
+		message, err := c.packer.UnPack(reader)
+		if err != nil {
+			c.disconnect(conn, err)
+			return
+		}
+		c.received.Store(true)
+		switch message.ID() {
+		case knet.MsgIDPing:
+			// 响应服务端的心跳
+			pong := knet.NewMessage(knet.MsgIDPong, message.Payload())
+			pong.PutSeq(message.Seq())
+			c.trySend(pong)
+			continue
+		case knet.MsgIDPong:
+			continue
+		case knet.MsgIDClose:
+			// 服务端关闭会话，以关闭原因作为连接断开的原因
+			if reason, ok := knet.ParseCloseReason(message); ok {
+				c.disconnect(conn, reason)
+				return
+			}
+		}
+		if message.Seq() != 0 && c.deliver(message) {
+			continue
+		}
+		if c.onMessage != nil {
+			c.onMessage(message)
+		}
+	}
+}
+
+// heartbeat 心跳协程，每个心跳间隔内未收到服务端的任何消息则发送一次 Ping，
+// 连续 maxMissedBeats 次仍未收到消息时断开连接(开启自动重连时将进行重连)
+func (c *Client) heartbeat(conn net.Conn) {
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			current := c.conn == conn
+			c.mu.Unlock()
+			if !current {
+				// 连接已断开
+				return
+			}
+			if c.received.Swap(false) {
+				missed = 0
+				continue
+			}
+			if missed >= c.maxMissedBeats {
+				c.disconnect(conn, ErrHeartbeatTimeout)
+				return
+			}
+			missed++
+			c.trySend(knet.NewMessage(knet.MsgIDPing, nil))
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// trySend 尝试将消息添加至发送队列，队列已满时丢弃
+func (c *Client) trySend(message kiface.IMessage) {
+	select {
+	case c.outChannel <- message:
+	default:
+	}
+}
+
+// writer 写协程，读取发送队列中的消息，写入到服务端连接
+// 写入失败的消息会在重连成功后重新发送
+func (c *Client) writer() {
+	for {
+		select {
+		case message := <-c.outChannel:
+			pack, err := c.packer.Pack(message)
+			if err != nil {
+				continue
+			}
+			c.write(pack)
+			knet.ReleasePack(c.packer, pack)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write 将数据包写入当前连接，写入失败时等待重连后重试，直到写入成功或者客户端关闭
+func (c *Client) write(pack []byte) {
+	for {
+		conn := c.waitConn()
+		if conn == nil {
+			return
+		}
+		_, err := conn.Write(pack)
+		if err == nil {
+			return
+		}
+		c.disconnect(conn, err)
+	}
+}
+
+// deliver 将响应消息交付给等待的请求，没有对应的请求时返回false
+func (c *Client) deliver(message kiface.IMessage) bool {
+	c.mu.Lock()
+	reply, ok := c.pending[message.Seq()]
+	delete(c.pending, message.Seq())
+	c.mu.Unlock()
+	if ok {
+		reply <- message
+	}
+	return ok
+}
+
+// removePending 移除等待的请求
+func (c *Client) removePending(seq uint64) {
+	c.mu.Lock()
+	delete(c.pending, seq)
+	c.mu.Unlock()
+}
+
+// allocSeq 分配请求序列号，0 保留为不携带序列号
+func (c *Client) allocSeq() uint64 {
+	for {
+		if seq := atomic.AddUint64(&c.nextSeq, 1); seq != 0 {
+			return seq
+		}
+	}
+}
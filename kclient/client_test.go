@@ -0,0 +1,199 @@
+// @Title client_test.go
+// @Description 客户端的发送、请求/响应关联、错误响应以及关闭的测试
+// @Author Zero - 2023/10/22 17:48:26
+
+package kclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"github.com/zlx2019/kinx/knet"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试服务端处理器支持的消息ID
+const (
+	// 乱序响应 "echo:" + 请求内容
+	echoID uint64 = iota + 1
+	// 以错误消息响应
+	failID
+	// 不响应
+	silentID
+)
+
+// testHandler 测试服务端的处理器，未列出的消息ID记录至 received
+type testHandler struct {
+	kiface.SuperHandler
+	received chan kiface.IMessage
+}
+
+func (h *testHandler) OnHandler(ctx kiface.IHandlerContext) error {
+	message := ctx.GetMessage()
+	switch message.ID() {
+	case echoID:
+		// 按序列号延迟响应，使响应的顺序与请求的顺序不同
+		reply := knet.NewMessage(echoID, append([]byte("echo:"), message.Payload()...))
+		reply.PutSeq(message.Seq())
+		session := ctx.GetSession()
+		go func() {
+			time.Sleep(time.Duration(5-message.Seq()%5) * time.Millisecond)
+			_ = session.Send(reply)
+		}()
+		return nil
+	case failID:
+		return ctx.ReplyError(418, "teapot")
+	case silentID:
+		return nil
+	}
+	h.received <- message
+	return nil
+}
+
+// startServer 在临时目录的 Unix 域套接字上运行服务端，返回服务端以及套接字文件路径，测试结束时关闭服务端
+func startServer(t *testing.T, opts ...knet.NormalServerOption) (*knet.NormalServer, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kinx.sock")
+	opts = append([]knet.NormalServerOption{knet.WithListener(knet.ListenerConfig{Network: "unix", Address: path})}, opts...)
+	server := knet.NewNormalServer(opts...).(*knet.NormalServer)
+	go func() {
+		_ = server.Run()
+	}()
+	// 套接字文件在开始接收连接前创建，之后的连接由监听队列暂存
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return server, path
+}
+
+// dial 连接测试服务端，测试结束时关闭客户端
+func dial(t *testing.T, path string, opts ...Option) *Client {
+	t.Helper()
+	client, err := Dial(path, append([]Option{WithNetwork("unix")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestSend(t *testing.T) {
+	handler := &testHandler{received: make(chan kiface.IMessage, 1)}
+	_, path := startServer(t, knet.WithHandler(handler))
+	client := dial(t, path)
+	if err := client.Send(knet.NewMessage(100, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-handler.received:
+		if message.ID() != 100 || string(message.Payload()) != "hello" {
+			t.Fatalf("got id %d payload %q", message.ID(), message.Payload())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestCallConcurrent(t *testing.T) {
+	_, path := startServer(t, knet.WithHandler(&testHandler{}))
+	client := dial(t, path, WithSendQueueSize(64))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	const calls = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := fmt.Sprintf("call-%d", i)
+			reply, err := client.Call(ctx, knet.NewMessage(echoID, []byte(payload)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(reply.Payload()) != "echo:"+payload {
+				errs <- fmt.Errorf("call %d got %q", i, reply.Payload())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCallErrorReply(t *testing.T) {
+	_, path := startServer(t, knet.WithHandler(&testHandler{}))
+	client := dial(t, path)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := client.Call(ctx, knet.NewMessage(failID, nil))
+	var reply *knet.ErrorReply
+	if !errors.As(err, &reply) {
+		t.Fatalf("got %v, want *knet.ErrorReply", err)
+	}
+	if reply.Code != 418 || reply.Message != "teapot" {
+		t.Fatalf("got code %d message %q", reply.Code, reply.Message)
+	}
+}
+
+func TestCloseUnblocksCall(t *testing.T) {
+	_, path := startServer(t, knet.WithHandler(&testHandler{}))
+	client := dial(t, path)
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), knet.NewMessage(silentID, nil))
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = client.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Fatalf("got %v, want ErrClientClosed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Call not unblocked by Close")
+	}
+	if err := client.Send(knet.NewMessage(100, nil)); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("send after close: got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestCallUnknownRoute(t *testing.T) {
+	router := knet.NewRouter()
+	router.AddRoute(echoID, func(ctx kiface.IHandlerContext) error {
+		return ctx.Reply(ctx.GetMessage().Payload())
+	})
+	_, path := startServer(t, knet.WithRouter(router))
+	client := dial(t, path)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := client.Call(ctx, knet.NewMessage(404, nil))
+	if !errors.Is(err, ErrUnknownRoute) {
+		t.Fatalf("got %v, want ErrUnknownRoute", err)
+	}
+	// 未知路由不影响后续的请求
+	if _, err = client.Call(ctx, knet.NewMessage(echoID, []byte("ok"))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,20 @@
+// @Title errors.go
+// @Description 客户端错误定义
+// @Author Zero - 2023/9/27 16:02:45
+
+package kclient
+
+import "errors"
+
+var (
+	// ErrClientClosed 客户端已关闭
+	ErrClientClosed = errors.New("kclient: client closed")
+	// ErrQueueFull 连接断开期间发送队列已满
+	ErrQueueFull = errors.New("kclient: send queue full")
+	// ErrAuthFailed 认证失败
+	ErrAuthFailed = errors.New("kclient: authentication failed")
+	// ErrHeartbeatTimeout 连续多次未收到服务端的消息
+	ErrHeartbeatTimeout = errors.New("kclient: heartbeat timeout")
+	// ErrUnknownRoute 服务端没有请求消息ID对应的路由
+	ErrUnknownRoute = errors.New("kclient: unknown route")
+)
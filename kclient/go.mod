@@ -0,0 +1,3 @@
+module github.com/zlx2019/kinx/kclient
+
+go 1.20
@@ -0,0 +1,120 @@
+// @Title options.go
+// @Description 客户端配置选项
+// @Author Zero - 2023/9/27 16:05:12
+
+package kclient
+
+import (
+	"crypto/tls"
+	"github.com/zlx2019/kinx/kiface"
+	"time"
+)
+
+// Option 客户端的配置注册函数
+type Option func(client *Client)
+
+// WithPacker 设置消息封包与解包处理器，需与服务端保持一致，默认为 knet.NormalPacker
+func WithPacker(packer kiface.IPacker) Option {
+	return func(c *Client) {
+		c.packer = packer
+	}
+}
+
+// WithNetwork 设置连接的网络类型，默认为 "tcp"；连接 Unix 域套接字时设置为 "unix"，地址为套接字文件路径
+func WithNetwork(network string) Option {
+	return func(c *Client) {
+		c.network = network
+	}
+}
+
+// WithWebSocket 通过 WebSocket 协议连接服务端，path 为升级请求的路径，需与服务端 knet.WithWebSocket 一致
+// 与 WithTLSConfig 同时使用时为 wss
+func WithWebSocket(path string) Option {
+	return func(c *Client) {
+		c.isWebSocket = true
+		c.webSocketPath = path
+	}
+}
+
+// WithDialTimeout 设置建立连接的超时时间
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithTLSConfig 使用TLS连接服务端，双向认证时需在 Certificates 中设置客户端证书
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithAuth 设置认证函数，每次建立连接(包括重连)后执行，认证失败时放弃该连接
+// 内置的认证函数: TokenAuth、PasswordAuth、HMACAuth，需与服务端的认证器对应
+func WithAuth(auth AuthFunc) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithMessageHandler 设置服务端推送消息的处理函数
+// 未能关联到 Call 请求的消息(如服务端主动推送的消息)都将交由该函数处理，函数在读协程中执行，不可阻塞
+func WithMessageHandler(handler func(kiface.IMessage)) Option {
+	return func(c *Client) {
+		c.onMessage = handler
+	}
+}
+
+// WithSendQueueSize 设置发送队列的容量，同时也是连接断开期间最多缓存的待发送消息数量
+func WithSendQueueSize(size int) Option {
+	return func(c *Client) {
+		c.queueSize = size
+	}
+}
+
+// WithReconnect 开启断线自动重连
+// maxRetries 为最大连续重连次数，超过后客户端关闭，0 表示不限制
+func WithReconnect(maxRetries int) Option {
+	return func(c *Client) {
+		c.reconnect = true
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff 设置重连的退避间隔范围，重连间隔从 min 开始指数增长，最大不超过 max
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = backoff{min: min, max: max}
+	}
+}
+
+// WithHeartbeat 开启心跳，每个心跳间隔内未收到服务端的任何消息则发送一次 Ping，
+// 连续 maxMissed 次仍未收到消息时断开连接；无论是否开启，客户端都会响应服务端的 Ping.
+// interval <= 0 时不开启心跳；maxMissed < 1 时按 1 处理，保证断开前至少发送过一次 Ping
+func WithHeartbeat(interval time.Duration, maxMissed int) Option {
+	return func(c *Client) {
+		if interval < 0 {
+			interval = 0
+		}
+		if maxMissed < 1 {
+			maxMissed = 1
+		}
+		c.heartbeatInterval = interval
+		c.maxMissedBeats = maxMissed
+	}
+}
+
+// WithOnDisconnect 设置连接断开的回调函数，客户端主动关闭时不会回调
+func WithOnDisconnect(fn func(err error)) Option {
+	return func(c *Client) {
+		c.onDisconnect = fn
+	}
+}
+
+// WithOnReconnect 设置重连成功的回调函数
+func WithOnReconnect(fn func()) Option {
+	return func(c *Client) {
+		c.onReconnect = fn
+	}
+}
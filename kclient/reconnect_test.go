@@ -0,0 +1,142 @@
+// @Title reconnect_test.go
+// @Description 断线重连、重连后按序重发以及断线期间发送队列已满的测试
+// @Author Zero - 2023/10/22 18:06:13
+
+package kclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"github.com/zlx2019/kinx/knet"
+	"testing"
+	"time"
+)
+
+// dropSessions 在服务端关闭所有会话，模拟连接断开
+func dropSessions(server *knet.NormalServer) {
+	server.GetSessionManager().Range(func(session kiface.ISession) bool {
+		session.Stop()
+		return true
+	})
+}
+
+// waitSignal 等待通道中的信号
+func waitSignal[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s not observed", what)
+	}
+	var zero T
+	return zero
+}
+
+func TestReconnectReplaysQueued(t *testing.T) {
+	handler := &testHandler{received: make(chan kiface.IMessage, 16)}
+	server, path := startServer(t, knet.WithHandler(handler))
+	disconnected := make(chan error, 1)
+	reconnected := make(chan struct{}, 1)
+	client := dial(t, path,
+		WithReconnect(0),
+		WithBackoff(100*time.Millisecond, 100*time.Millisecond),
+		WithOnDisconnect(func(err error) { disconnected <- err }),
+		WithOnReconnect(func() { reconnected <- struct{}{} }),
+	)
+	if err := client.Send(knet.NewMessage(100, []byte("before"))); err != nil {
+		t.Fatal(err)
+	}
+	waitSignal(t, handler.received, "message before disconnect")
+
+	dropSessions(server)
+	if err := waitSignal(t, disconnected, "disconnect"); err == nil {
+		t.Fatal("disconnect callback without cause")
+	}
+	// 断线期间发送的消息暂存于发送队列，重连后按序发送
+	for i := 0; i < 5; i++ {
+		if err := client.Send(knet.NewMessage(100, []byte(fmt.Sprint(i)))); err != nil {
+			t.Fatalf("send %d while disconnected: %v", i, err)
+		}
+	}
+	waitSignal(t, reconnected, "reconnect")
+	for i := 0; i < 5; i++ {
+		message := waitSignal(t, handler.received, "replayed message")
+		if got := string(message.Payload()); got != fmt.Sprint(i) {
+			t.Fatalf("replayed message %d = %q", i, got)
+		}
+	}
+	// 重连后请求/响应正常
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, knet.NewMessage(echoID, []byte("after"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueFullWhileDisconnected(t *testing.T) {
+	server, path := startServer(t, knet.WithHandler(&testHandler{}))
+	disconnected := make(chan error, 1)
+	const queueSize = 2
+	client := dial(t, path,
+		WithSendQueueSize(queueSize),
+		WithReconnect(0),
+		WithBackoff(time.Hour, time.Hour),
+		WithOnDisconnect(func(err error) { disconnected <- err }),
+	)
+	// 完成一次请求，确保服务端已注册会话
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, knet.NewMessage(echoID, nil)); err != nil {
+		t.Fatal(err)
+	}
+	dropSessions(server)
+	waitSignal(t, disconnected, "disconnect")
+	// 写协程最多取走一个消息等待重连，其余消息占满发送队列
+	sent := 0
+	var err error
+	for ; sent <= queueSize+1; sent++ {
+		if err = client.Send(knet.NewMessage(100, nil)); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v after %d sends, want ErrQueueFull", err, sent)
+	}
+	if sent < queueSize {
+		t.Fatalf("ErrQueueFull after %d sends, queue size %d", sent, queueSize)
+	}
+}
+
+func TestReconnectMaxRetries(t *testing.T) {
+	server, path := startServer(t, knet.WithHandler(&testHandler{}))
+	client := dial(t, path, WithReconnect(2), WithBackoff(10*time.Millisecond, 10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, knet.NewMessage(echoID, nil)); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.Shutdown(ctx)
+	// 服务端不再可用，超过最大重连次数后客户端关闭
+	waitSignal(t, client.Done(), "client close")
+	if err := client.Send(knet.NewMessage(100, nil)); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: 100 * time.Millisecond, max: time.Second}
+	for attempt := 0; attempt < 40; attempt++ {
+		want := b.max
+		if attempt < 4 {
+			want = b.min << uint(attempt)
+		}
+		for i := 0; i < 20; i++ {
+			if d := b.next(attempt); d < want/2 || d > want {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, d, want/2, want)
+			}
+		}
+	}
+}
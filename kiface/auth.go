@@ -0,0 +1,13 @@
+// @Title auth.go
+// @Description 会话认证抽象层
+// @Author Zero - 2023/10/11 09:42:18
+
+package kiface
+
+// IAuthenticator 会话认证器接口
+// 会话建立后、注册到会话管理器以及开始处理消息之前进行认证，认证失败的会话将被关闭
+type IAuthenticator interface {
+	// Authenticate 对会话进行认证，可通过 session 的 Read 与 Write 与客户端进行一次或多次消息交互
+	// 认证成功返回认证主体(如用户信息)，可通过 ISession.GetPrincipal 获取
+	Authenticate(session ISession) (principal any, err error)
+}
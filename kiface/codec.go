@@ -0,0 +1,13 @@
+// @Title codec.go
+// @Description 消息内容编解码器抽象层
+// @Author Zero - 2023/10/18 10:05:41
+
+package kiface
+
+// ICodec 消息内容编解码器，负责业务对象与消息内容之间的转换
+type ICodec interface {
+	// Marshal 将对象编码为消息内容
+	Marshal(v any) ([]byte, error)
+	// Unmarshal 将消息内容解码至对象，v 须为指针
+	Unmarshal(data []byte, v any) error
+}
@@ -14,4 +14,18 @@ type IHandlerContext interface {
 	Put(key, value any)
 	// Get 根据Key获取上下文数据
 	Get(any) any
+	// Next 执行处理链中后续的中间件与处理函数，仅在中间件内调用
+	Next() error
+	// Abort 中止处理链，后续的中间件与处理函数将不再执行
+	Abort()
+	// IsAborted 处理链是否已被中止
+	IsAborted() bool
+	// Reply 响应本次请求，响应消息的ID以及序列号与请求相同，经由会话的写队列发送
+	Reply(payload []byte) error
+	// ReplyError 以错误消息响应本次请求，携带请求的序列号，客户端可据此将错误与请求关联
+	ReplyError(code uint32, msg string) error
+	// Bind 使用服务端的编解码器将消息内容解码至 v，v 须为指针
+	Bind(v any) error
+	// ReplyObject 使用服务端的编解码器编码 v，并以编码结果响应本次请求
+	ReplyObject(v any) error
 }
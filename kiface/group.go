@@ -0,0 +1,35 @@
+// @Title group.go
+// @Description 会话分组抽象层，用于向部分会话组播消息(如聊天室、游戏大厅)
+// @Author Zero - 2023/10/4 15:08:21
+
+package kiface
+
+// IGroup 会话分组接口
+type IGroup interface {
+	// Name 获取分组名称
+	Name() string
+	// Count 获取分组内的会话数量
+	Count() int
+	// Has 会话是否在分组内
+	Has(sessionID uint32) bool
+	// Range 遍历分组内的会话，fn 返回false时停止遍历
+	Range(fn func(session ISession) bool)
+	// Broadcast 向分组内的所有会话发送消息，不会阻塞在发送队列已满的会话上，返回成功加入发送队列的会话数量
+	Broadcast(message IMessage) int
+}
+
+// IGroupManager 会话分组管理器接口
+type IGroupManager interface {
+	// Get 根据名称获取分组
+	Get(name string) (IGroup, bool)
+	// Join 将会话加入分组，分组不存在时自动创建
+	Join(name string, session ISession)
+	// Leave 将会话移出分组，分组为空时自动删除
+	Leave(name string, session ISession)
+	// Range 遍历所有分组，fn 返回false时停止遍历
+	Range(fn func(group IGroup) bool)
+	// OnJoin 设置会话加入分组的回调函数
+	OnJoin(fn func(group IGroup, session ISession))
+	// OnLeave 设置会话离开分组的回调函数
+	OnLeave(fn func(group IGroup, session ISession))
+}
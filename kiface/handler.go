@@ -20,6 +20,13 @@ type IHandler interface {
 	OnHandler(IHandlerContext) error
 }
 
+// HandlerFunc 数据处理函数，路由器根据消息ID将消息分发至对应的 HandlerFunc
+type HandlerFunc func(IHandlerContext) error
+
+// Middleware 中间件，包裹数据处理函数，用于鉴权、日志、异常恢复等横切逻辑
+// 调用 next 执行后续的中间件与处理函数，等同于 IHandlerContext.Next
+type Middleware func(ctx IHandlerContext, next func() error) error
+
 // SuperHandler IHandler的抽象实现，业务处理器继承于此实现后就无需重写所有接口
 type SuperHandler struct {
 }
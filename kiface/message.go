@@ -12,6 +12,8 @@ type IMessage interface {
 	ID() uint64
 	// Payload 获取消息内容
 	Payload() []byte
+	// Seq 获取消息序列号，用于请求与响应的关联，0 表示不携带序列号
+	Seq() uint64
 
 	// PutID 设置消息ID
 	PutID(uint64)
@@ -19,4 +21,26 @@ type IMessage interface {
 	PutLen(uint64)
 	// PutPayload 设置消息的内容
 	PutPayload([]byte)
+	// PutSeq 设置消息序列号
+	PutSeq(uint64)
+
+	// Release 将消息内容的缓冲区归还缓冲池，调用后不能再使用 Payload 返回的数据
+	// 由消息处理器解包的消息，其内容缓冲区来自缓冲池；处理器不再使用消息内容时可调用以减少内存分配，不调用时由GC回收.
+	// 重复调用是安全的，非缓冲池分配的消息调用无任何效果.
+	Release()
+}
+
+// IMessageHeader 消息的扩展头部: 标志位以及 key/value 元数据(如链路追踪ID)
+// 消息通过类型断言获取扩展头部，不支持扩展头部的消息处理器将忽略这些字段
+type IMessageHeader interface {
+	// Flags 获取消息标志位
+	Flags() uint16
+	// PutFlags 设置消息标志位
+	PutFlags(uint16)
+	// Meta 根据Key获取元数据
+	Meta(key string) (string, bool)
+	// PutMeta 设置元数据
+	PutMeta(key, value string)
+	// Metadata 获取全部元数据，未设置时为nil
+	Metadata() map[string]string
 }
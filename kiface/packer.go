@@ -9,6 +9,8 @@ import "io"
 // IPacker 消息包处理器接口，消息封包与消息拆包
 type IPacker interface {
 	// Pack 消息打包
+	// 返回的数据包归调用方所有，框架写入连接后不会再使用，也不会将其归还给任何缓冲池；
+	// 内置消息处理器的数据包由框架通过 knet.ReleasePack 归还至 knet 缓冲池
 	Pack(IMessage) ([]byte, error)
 	// UnPack 消息拆包
 	UnPack(reader io.Reader) (IMessage, error)
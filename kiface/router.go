@@ -0,0 +1,17 @@
+// @Title router.go
+// @Description 消息路由抽象层
+// @Author Zero - 2023/9/25 10:12:41
+
+package kiface
+
+// IRouter 消息路由器接口，根据消息ID将消息分发至对应的处理函数
+type IRouter interface {
+	// AddRoute 注册消息ID对应的处理函数，以及该路由独有的中间件
+	AddRoute(id uint64, handler HandlerFunc, middlewares ...Middleware)
+	// AddRangeRoute 注册消息ID区间 [min, max] 对应的处理函数，以及该路由独有的中间件
+	AddRangeRoute(min, max uint64, handler HandlerFunc, middlewares ...Middleware)
+	// NotFound 设置未匹配到路由时的处理函数
+	NotFound(handler HandlerFunc)
+	// Match 根据消息ID匹配处理链(路由中间件 + 处理函数)，未匹配到时返回 NotFound 处理函数
+	Match(id uint64) []HandlerFunc
+}
@@ -4,11 +4,13 @@
 
 package kiface
 
+import "context"
+
 // IServer Server abstract interface
 // 服务端顶级接口
 type IServer interface {
 	// Run 启动并且运行处理服务
 	Run() error
-	// Shutdown 关闭服务
-	Shutdown() error
+	// Shutdown 优雅关闭服务，ctx 到期后强制关闭剩余的会话
+	Shutdown(ctx context.Context) error
 }
@@ -21,12 +21,37 @@ type ISession interface {
 	GetRemoteAddr() net.Addr
 	// GetContext 获取会话的上下文
 	GetContext() context.Context
+	// GetPrincipal 获取会话认证通过后的认证主体，未开启认证时为nil
+	GetPrincipal() any
 	// Read 从连接中读取数据，并且解包为IMessage
 	Read(duration time.Duration) (IMessage, error)
 	// Write 向连接写入数据包
 	Write(message IMessage) error
+	// Send 将消息添加至会话的发送队列，由写协程异步写入连接
+	Send(message IMessage) error
+	// Join 加入指定名称的分组，会话关闭时自动离开所有分组
+	Join(group string) error
+	// Leave 离开指定名称的分组
+	Leave(group string)
 	// Stop 关闭会话连接
 	Stop()
 	// IsClose 会话是否已关闭
 	IsClose() bool
 }
+
+// ISessionManager 会话管理器接口，管理服务端所有存活的会话
+type ISessionManager interface {
+	// Add 添加会话
+	Add(session ISession)
+	// Remove 移除会话
+	Remove(session ISession)
+	// Get 根据会话ID获取会话
+	Get(id uint32) (ISession, bool)
+	// Range 遍历所有会话，fn 返回false时停止遍历
+	Range(fn func(session ISession) bool)
+	// Count 获取存活的会话数量
+	Count() int
+	// Broadcast 向所有满足 filter 的会话发送消息，filter 为nil时发送给所有会话，返回消息送达的会话数量
+	// 不会阻塞在发送队列已满的慢速会话上
+	Broadcast(message IMessage, filter func(session ISession) bool) int
+}
@@ -0,0 +1,140 @@
+// @Title auth.go
+// @Description 内置的会话认证器: 令牌认证、用户名密码认证以及 HMAC 挑战/应答认证
+// @Author Zero - 2023/10/11 10:05:37
+
+package knet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"strings"
+	"time"
+)
+
+const (
+	// 默认的会话认证超时时间
+	defaultAuthTimeout = time.Second * 10
+	// 认证过程中单次读取消息的超时时间，整体超时由 WithAuthenticator 控制
+	authReadTimeout = time.Second * 5
+	// HMAC 认证挑战随机数的字节数
+	challengeSize = 32
+	// 认证失败时响应给客户端的原因，具体的错误只在服务端输出，不暴露给未认证的客户端
+	authFailedReason = "authentication failed"
+)
+
+// TokenVerifier 令牌校验函数，校验通过返回认证主体
+type TokenVerifier func(token string) (any, error)
+
+// PasswordVerifier 用户名密码校验函数，校验通过返回认证主体
+type PasswordVerifier func(username, password string) (any, error)
+
+// SecretLoader 根据客户端ID获取 HMAC 密钥
+type SecretLoader func(clientID string) ([]byte, error)
+
+// AuthenticatorFunc 函数形式的认证器
+type AuthenticatorFunc func(session kiface.ISession) (any, error)
+
+// Authenticate 对会话进行认证
+func (f AuthenticatorFunc) Authenticate(session kiface.ISession) (any, error) {
+	return f(session)
+}
+
+// NewTokenAuthenticator 令牌认证器
+// 客户端发送一条 MsgIDAuth 消息，内容为令牌
+func NewTokenAuthenticator(verify TokenVerifier) kiface.IAuthenticator {
+	return AuthenticatorFunc(func(session kiface.ISession) (any, error) {
+		credential, err := readCredential(session)
+		if err != nil {
+			return nil, err
+		}
+		principal, err := verify(string(credential))
+		return replyAuth(session, principal, err)
+	})
+}
+
+// NewPasswordAuthenticator 用户名密码认证器
+// 客户端发送一条 MsgIDAuth 消息，内容为 "用户名:密码"，用户名中不可包含 ':'
+func NewPasswordAuthenticator(verify PasswordVerifier) kiface.IAuthenticator {
+	return AuthenticatorFunc(func(session kiface.ISession) (any, error) {
+		credential, err := readCredential(session)
+		if err != nil {
+			return nil, err
+		}
+		username, password, ok := strings.Cut(string(credential), ":")
+		if !ok {
+			return replyAuth(session, nil, fmt.Errorf("%w: malformed credential", ErrAuthFailed))
+		}
+		principal, err := verify(username, password)
+		return replyAuth(session, principal, err)
+	})
+}
+
+// NewHMACAuthenticator HMAC 挑战/应答认证器，密钥不会在网络中传输
+// 1. 客户端发送 MsgIDAuth 消息，内容为客户端ID;
+// 2. 服务端响应 MsgIDAuthChallenge 消息，内容为 32 byte 的随机数;
+// 3. 客户端发送 MsgIDAuth 消息，内容为 HMAC-SHA256(密钥, 随机数);
+// 认证成功后认证主体为客户端ID.
+func NewHMACAuthenticator(load SecretLoader) kiface.IAuthenticator {
+	return AuthenticatorFunc(func(session kiface.ISession) (any, error) {
+		clientID, err := readCredential(session)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := load(string(clientID))
+		if err != nil {
+			return replyAuth(session, nil, err)
+		}
+		// 发送挑战
+		challenge := make([]byte, challengeSize)
+		if _, err = rand.Read(challenge); err != nil {
+			return nil, err
+		}
+		if err = session.Write(NewMessage(MsgIDAuthChallenge, challenge)); err != nil {
+			return nil, err
+		}
+		// 校验应答
+		answer, err := readCredential(session)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(answer, SignChallenge(secret, challenge)) {
+			return replyAuth(session, nil, fmt.Errorf("%w: invalid signature", ErrAuthFailed))
+		}
+		return replyAuth(session, string(clientID), nil)
+	})
+}
+
+// SignChallenge 使用密钥对挑战随机数进行签名
+func SignChallenge(secret, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// readCredential 读取客户端发送的 MsgIDAuth 消息内容
+func readCredential(session kiface.ISession) ([]byte, error) {
+	message, err := session.Read(authReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if message.ID() != MsgIDAuth {
+		return nil, fmt.Errorf("%w: unexpected message ID %d", ErrAuthFailed, message.ID())
+	}
+	return message.Payload(), nil
+}
+
+// replyAuth 向客户端响应认证结果
+// 认证失败时客户端只会收到固定的失败原因，具体的错误返回给服务端，在会话建立失败时输出
+func replyAuth(session kiface.ISession, principal any, err error) (any, error) {
+	if err != nil {
+		_ = session.Write(NewMessage(MsgIDAuthFailed, []byte(authFailedReason)))
+		return nil, err
+	}
+	if err = session.Write(NewMessage(MsgIDAuthOK, nil)); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
@@ -0,0 +1,182 @@
+// @Title auth_test.go
+// @Description 令牌、用户名密码以及 HMAC 认证器的测试，认证失败原因不泄露服务端错误，认证超时关闭连接
+// @Author Zero - 2023/10/22 18:24:50
+
+package knet
+
+import (
+	"bufio"
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errBackend 认证后端的内部错误，不应被发送给客户端
+var errBackend = errors.New("backend lookup failed: db-01 unreachable")
+
+// authClient 认证阶段的测试客户端
+type authClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	packer *NormalPacker
+}
+
+func dialAuth(t *testing.T, addr net.Addr) *authClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	return &authClient{conn: conn, reader: bufio.NewReader(conn), packer: NewNormalPacker().(*NormalPacker)}
+}
+
+func (c *authClient) send(t *testing.T, message kiface.IMessage) {
+	t.Helper()
+	pack, err := c.packer.Pack(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.conn.Write(pack); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *authClient) recv(t *testing.T) kiface.IMessage {
+	t.Helper()
+	message, err := c.packer.UnPack(c.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// expectOK 认证成功后会话可以正常处理业务消息
+func (c *authClient) expectOK(t *testing.T) {
+	t.Helper()
+	if result := c.recv(t); result.ID() != MsgIDAuthOK {
+		t.Fatalf("got message ID %d, want MsgIDAuthOK", result.ID())
+	}
+	c.send(t, NewMessage(1, []byte("hello")))
+	if reply := c.recv(t); string(reply.Payload()) != "echo:hello" {
+		t.Fatalf("got %q after auth", reply.Payload())
+	}
+}
+
+// expectFailed 认证失败时客户端只收到固定的失败原因，随后连接被关闭
+func (c *authClient) expectFailed(t *testing.T) {
+	t.Helper()
+	result := c.recv(t)
+	if result.ID() != MsgIDAuthFailed {
+		t.Fatalf("got message ID %d, want MsgIDAuthFailed", result.ID())
+	}
+	if string(result.Payload()) != authFailedReason {
+		t.Fatalf("failure reason %q leaks server error", result.Payload())
+	}
+	if _, err := c.packer.UnPack(c.reader); err == nil {
+		t.Fatal("connection still open after failed auth")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	auth := NewTokenAuthenticator(func(token string) (any, error) {
+		switch token {
+		case "secret-token":
+			return "alice", nil
+		case "broken":
+			return nil, errBackend
+		}
+		return nil, ErrAuthFailed
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithAuthenticator(auth, time.Second))
+	for _, token := range []string{"wrong", "broken"} {
+		client := dialAuth(t, addr)
+		client.send(t, NewMessage(MsgIDAuth, []byte(token)))
+		client.expectFailed(t)
+	}
+	client := dialAuth(t, addr)
+	client.send(t, NewMessage(MsgIDAuth, []byte("secret-token")))
+	client.expectOK(t)
+}
+
+func TestPasswordAuthenticator(t *testing.T) {
+	auth := NewPasswordAuthenticator(func(username, password string) (any, error) {
+		if username == "alice" && password == "p:w" {
+			return username, nil
+		}
+		return nil, errBackend
+	})
+	server, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithAuthenticator(auth, time.Second))
+	for _, credential := range []string{"alice:wrong", "malformed"} {
+		client := dialAuth(t, addr)
+		client.send(t, NewMessage(MsgIDAuth, []byte(credential)))
+		client.expectFailed(t)
+	}
+	// 仅以第一个 ':' 分隔用户名与密码
+	client := dialAuth(t, addr)
+	client.send(t, NewMessage(MsgIDAuth, []byte("alice:p:w")))
+	client.expectOK(t)
+	server.GetSessionManager().Range(func(session kiface.ISession) bool {
+		if session.GetPrincipal() != "alice" {
+			t.Errorf("principal = %v, want alice", session.GetPrincipal())
+		}
+		return true
+	})
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("shared-secret")
+	auth := NewHMACAuthenticator(func(clientID string) ([]byte, error) {
+		if clientID == "device-1" {
+			return secret, nil
+		}
+		return nil, errBackend
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithAuthenticator(auth, time.Second))
+	handshake := func(clientID string, key []byte) *authClient {
+		client := dialAuth(t, addr)
+		client.send(t, NewMessage(MsgIDAuth, []byte(clientID)))
+		challenge := client.recv(t)
+		if challenge.ID() != MsgIDAuthChallenge || len(challenge.Payload()) != challengeSize {
+			t.Fatalf("got message ID %d with %d bytes, want challenge", challenge.ID(), len(challenge.Payload()))
+		}
+		client.send(t, NewMessage(MsgIDAuth, SignChallenge(key, challenge.Payload())))
+		return client
+	}
+	handshake("device-1", secret).expectOK(t)
+	handshake("device-1", []byte("wrong-secret")).expectFailed(t)
+	// 未知的客户端ID不会收到挑战
+	client := dialAuth(t, addr)
+	client.send(t, NewMessage(MsgIDAuth, []byte("device-2")))
+	client.expectFailed(t)
+}
+
+func TestAuthTimeout(t *testing.T) {
+	auth := NewTokenAuthenticator(func(string) (any, error) { return nil, nil })
+	server, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithAuthenticator(auth, 100*time.Millisecond))
+	client := dialAuth(t, addr)
+	start := time.Now()
+	// 不发送凭证，认证超时后连接被关闭
+	_, err := client.packer.UnPack(client.reader)
+	if err == nil {
+		t.Fatal("got a message before auth timeout")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("connection not closed by the auth timeout")
+	}
+	if !errors.Is(err, io.EOF) && !strings.Contains(err.Error(), "reset") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("closed after %s, want about 100ms", elapsed)
+	}
+	if count := server.GetSessionManager().Count(); count != 0 {
+		t.Fatalf("sessions = %d, unauthenticated session registered", count)
+	}
+}
@@ -0,0 +1,122 @@
+// @Title batch_writer.go
+// @Description 写协程的批量写入，将队列中的多个消息合并为一次系统调用
+// @Author Zero - 2023/10/21 15:12:08
+
+package knet
+
+import (
+	"bytes"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"time"
+)
+
+// defaultWriteBatchSize 默认的单次批量写入的最大消息数量
+const defaultWriteBatchSize = 64
+
+// collect 收集一批待写出的消息: 取出发送队列中已有的消息，直到达到最大批量
+// timer 不为nil时，队列为空后继续等待新的消息，直到等待超时、达到最大批量或会话关闭
+func (ns *NormalSession) collect(batch []kiface.IMessage, timer *time.Timer) []kiface.IMessage {
+	max := ns.server.writeBatchSize
+drain:
+	for len(batch) < max {
+		select {
+		case message := <-ns.outChannel:
+			batch = append(batch, message)
+		default:
+			break drain
+		}
+	}
+	if len(batch) >= max || timer == nil {
+		return batch
+	}
+	timer.Reset(ns.server.writeBatchLatency)
+	defer func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}()
+	for len(batch) < max {
+		select {
+		case message := <-ns.outChannel:
+			batch = append(batch, message)
+		case <-timer.C:
+			return batch
+		case <-ns.flushing:
+			return batch
+		case <-ns.context.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch 将一批消息封包后一次写入连接，单个消息封包失败时跳过该消息
+func (ns *NormalSession) writeBatch(batch []kiface.IMessage) error {
+	if len(batch) == 1 {
+		ns.flushes.Add(1)
+		return ns.Write(batch[0])
+	}
+	buffers := make(net.Buffers, 0, len(batch))
+	active := false
+	for _, message := range batch {
+		pack, err := ns.packer.Pack(message)
+		if err != nil {
+			continue
+		}
+		buffers = append(buffers, pack)
+		active = active || !isHeartbeat(message)
+	}
+	if len(buffers) == 0 {
+		return nil
+	}
+	ns.flushes.Add(1)
+	// writev 会消耗 buffers，提前保留数据包以便写入后归还缓冲区
+	packs := make([][]byte, len(buffers))
+	copy(packs, buffers)
+	err := writeBuffers(ns.Conn, buffers)
+	for _, pack := range packs {
+		ReleasePack(ns.packer, pack)
+	}
+	if err != nil {
+		return err
+	}
+	if active {
+		ns.lastActive.Store(time.Now().UnixNano())
+	}
+	return nil
+}
+
+// resetBatch 清空已写出的批量消息，释放对消息的引用以便回收
+func resetBatch(batch []kiface.IMessage) []kiface.IMessage {
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+// writeBuffers 将多个数据包写入连接
+// TCP 以及 Unix 域套接字使用 writev 一次写出；WebSocket 连接每个帧只携带一个数据包，逐个写出；
+// 其他连接(如TLS)合并为一个缓冲区后一次写出.
+func writeBuffers(conn net.Conn, buffers net.Buffers) error {
+	if c, ok := conn.(*listenerConn); ok {
+		conn = c.Conn
+	}
+	switch c := conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		_, err := buffers.WriteTo(c)
+		return err
+	case *wsConn:
+		for _, buffer := range buffers {
+			if _, err := c.Write(buffer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := conn.Write(bytes.Join(buffers, nil))
+	return err
+}
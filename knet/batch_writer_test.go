@@ -0,0 +1,81 @@
+// @Title batch_writer_test.go
+// @Description 批量写入开启与关闭时，每个消息平均触发的写入次数基准对比
+// @Author Zero - 2023/10/22 16:58:20
+
+package knet
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchmarkWriteBatch 通过本地回环TCP连接向客户端推送 b.N 个消息，并报告每个消息平均的写入次数
+func benchmarkWriteBatch(b *testing.B, opts ...NormalServerOption) {
+	server, addr := startTestServer(b, ListenerConfig{}, opts...)
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	var session *NormalSession
+	for deadline := time.Now().Add(3 * time.Second); session == nil; {
+		server.GetSessionManager().Range(func(s kiface.ISession) bool {
+			session, _ = s.(*NormalSession)
+			return false
+		})
+		if session == nil {
+			if time.Now().After(deadline) {
+				b.Fatal("session not created")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 客户端持续读取推送的消息，读满 b.N 个后结束
+	received := make(chan error, 1)
+	go func() {
+		packer := NewNormalPacker()
+		reader := bufio.NewReader(conn)
+		for i := 0; i < b.N; i++ {
+			message, err := packer.UnPack(reader)
+			if err != nil {
+				received <- err
+				return
+			}
+			message.Release()
+		}
+		received <- nil
+	}()
+
+	payload := bytes.Repeat([]byte{'k'}, 64)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := session.Send(NewMessage(1024, payload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := <-received; err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	stats := session.QueueStats()
+	b.ReportMetric(float64(stats.Flushes)/float64(stats.Enqueued), "flushes/msg")
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	b.Run("Off", func(b *testing.B) {
+		benchmarkWriteBatch(b, WithWriteBatch(1, 0))
+	})
+	b.Run("On", func(b *testing.B) {
+		benchmarkWriteBatch(b)
+	})
+	b.Run("OnLatency", func(b *testing.B) {
+		benchmarkWriteBatch(b, WithWriteBatch(defaultWriteBatchSize, 50*time.Microsecond))
+	})
+}
@@ -0,0 +1,87 @@
+// @Title buffer_pool.go
+// @Description 按容量分级的字节缓冲池，用于消息的封包与解包
+// @Author Zero - 2023/10/22 09:48:37
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minBufferShift 最小的缓冲区容量等级 64B
+	minBufferShift = 6
+	// maxBufferShift 最大的缓冲区容量等级 64KB，超过该容量的缓冲区直接分配，不放回缓冲池
+	maxBufferShift = 16
+)
+
+// bufferPoolDisabled 关闭缓冲池，每次均直接分配，用于基准测试对比缓冲池的收益
+var bufferPoolDisabled bool
+
+// bufferPools 每个容量等级一个缓冲池，第 i 个缓冲池中的缓冲区容量为 1 << (minBufferShift + i)
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass 获取容纳 size 字节所需的容量等级，超过最大等级时返回 -1
+func bufferClass(size int) int {
+	if size <= 1<<minBufferShift {
+		return 0
+	}
+	class := bits.Len(uint(size-1)) - minBufferShift
+	if class > maxBufferShift-minBufferShift {
+		return -1
+	}
+	return class
+}
+
+// GetBuffer 从缓冲池获取长度为 size 的字节缓冲区，缓冲区中可能残留旧数据
+// 使用完毕后可通过 PutBuffer 放回缓冲池；不放回时由GC回收，不会造成泄漏
+func GetBuffer(size int) []byte {
+	return *getBuffer(size)
+}
+
+// PutBuffer 将缓冲区放回缓冲池，放回后调用方不能再使用该缓冲区
+// 仅接收容量恰好为某个等级的缓冲区，其他缓冲区直接忽略
+func PutBuffer(buf []byte) {
+	putBuffer(&buf)
+}
+
+// getBuffer 从缓冲池获取长度为 size 的缓冲区指针
+// 缓冲池中保存的是缓冲区指针，持有指针并通过 putBuffer 归还时不会产生额外的内存分配
+func getBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class < 0 || bufferPoolDisabled {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make([]byte, size, 1<<(minBufferShift+class))
+	return &buf
+}
+
+// putBuffer 将缓冲区指针放回缓冲池
+func putBuffer(buf *[]byte) {
+	class := bufferClass(cap(*buf))
+	if class < 0 || bufferPoolDisabled || cap(*buf) != 1<<(minBufferShift+class) {
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPools[class].Put(buf)
+}
+
+// pooledPacker 数据包缓冲区取自缓冲池的消息处理器，仅由内置消息处理器实现
+type pooledPacker interface {
+	pooledPack() bool
+}
+
+// ReleasePack 将 packer.Pack 返回的数据包缓冲区归还至缓冲池，归还后调用方不能再使用该数据包
+// 仅归还内置消息处理器的数据包；自定义消息处理器返回的数据包可能仍被其持有，直接忽略
+func ReleasePack(packer kiface.IPacker, pack []byte) {
+	if p, ok := packer.(pooledPacker); ok && p.pooledPack() {
+		PutBuffer(pack)
+	}
+}
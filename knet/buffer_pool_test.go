@@ -0,0 +1,101 @@
+// @Title buffer_pool_test.go
+// @Description 缓冲池开启与关闭时 NormalPacker 的封包与解包基准对比，以及数据包归还规则的测试
+// @Author Zero - 2023/10/22 17:12:40
+
+package knet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"testing"
+)
+
+// withBufferPool 在缓冲池开启与关闭时分别运行基准测试
+func withBufferPool(b *testing.B, fn func(b *testing.B)) {
+	for _, disabled := range []bool{false, true} {
+		name := "Pool"
+		if disabled {
+			name = "NoPool"
+		}
+		b.Run(name, func(b *testing.B) {
+			bufferPoolDisabled = disabled
+			defer func() { bufferPoolDisabled = false }()
+			fn(b)
+		})
+	}
+}
+
+func BenchmarkNormalPackerPack(b *testing.B) {
+	packer := NewNormalPacker()
+	withBufferPool(b, func(b *testing.B) {
+		for _, size := range benchmarkPayloadSizes {
+			message := NewMessage(1024, bytes.Repeat([]byte{'k'}, size))
+			b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					pack, err := packer.Pack(message)
+					if err != nil {
+						b.Fatal(err)
+					}
+					ReleasePack(packer, pack)
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkNormalPackerUnPack(b *testing.B) {
+	packer := NewNormalPacker()
+	withBufferPool(b, func(b *testing.B) {
+		for _, size := range benchmarkPayloadSizes {
+			pack, err := packer.Pack(NewMessage(1024, bytes.Repeat([]byte{'k'}, size)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				reader := bytes.NewReader(pack)
+				for i := 0; i < b.N; i++ {
+					reader.Reset(pack)
+					message, err := packer.UnPack(reader)
+					if err != nil {
+						b.Fatal(err)
+					}
+					message.Release()
+				}
+			})
+		}
+	})
+}
+
+// fixedPacker 自定义消息处理器，每次封包都返回自身持有的同一个缓冲区
+type fixedPacker struct {
+	buf []byte
+}
+
+func (p *fixedPacker) Pack(kiface.IMessage) ([]byte, error) {
+	return p.buf, nil
+}
+
+func (p *fixedPacker) UnPack(io.Reader) (kiface.IMessage, error) {
+	return nil, io.EOF
+}
+
+func TestReleasePackIgnoresCustomPacker(t *testing.T) {
+	packer := &fixedPacker{buf: make([]byte, 64)}
+	for i := 0; i < 8; i++ {
+		pack, _ := packer.Pack(nil)
+		ReleasePack(packer, pack)
+		if buf := GetBuffer(64); &buf[0] == &packer.buf[0] {
+			t.Fatal("buffer owned by custom packer was recycled")
+		}
+	}
+	compress := NewCompressPacker(NewNormalPacker())
+	if p, ok := compress.(pooledPacker); !ok || !p.pooledPack() {
+		t.Fatal("compress packer over built-in packer should release its packs")
+	}
+}
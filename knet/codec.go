@@ -0,0 +1,90 @@
+// @Title codec.go
+// @Description 内置的消息内容编解码器: JSON、gob 以及二进制(protobuf 风格)
+// @Author Zero - 2023/10/18 10:21:56
+
+package knet
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+)
+
+// JSONCodec JSON 编解码器，服务端默认使用的编解码器
+type JSONCodec struct{}
+
+// NewJSONCodec 构造函数
+func NewJSONCodec() kiface.ICodec {
+	return JSONCodec{}
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec gob 编解码器，每条消息独立编码(携带类型信息)，通信双方均需为 Go 程序
+type GobCodec struct{}
+
+// NewGobCodec 构造函数
+func NewGobCodec() kiface.ICodec {
+	return GobCodec{}
+}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// binaryMarshaler protobuf 风格的编码接口，如 gogo/protobuf 生成的消息类型
+type binaryMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// binaryUnmarshaler protobuf 风格的解码接口
+type binaryUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// BinaryCodec 二进制编解码器，由对象自身完成编解码
+// 对象须实现 encoding.BinaryMarshaler / encoding.BinaryUnmarshaler，
+// 或者 protobuf 风格的 Marshal() ([]byte, error) / Unmarshal([]byte) error.
+type BinaryCodec struct{}
+
+// NewBinaryCodec 构造函数
+func NewBinaryCodec() kiface.ICodec {
+	return BinaryCodec{}
+}
+
+func (BinaryCodec) Marshal(v any) ([]byte, error) {
+	switch m := v.(type) {
+	case encoding.BinaryMarshaler:
+		return m.MarshalBinary()
+	case binaryMarshaler:
+		return m.Marshal()
+	}
+	return nil, fmt.Errorf("knet: %T does not implement encoding.BinaryMarshaler", v)
+}
+
+func (BinaryCodec) Unmarshal(data []byte, v any) error {
+	switch m := v.(type) {
+	case encoding.BinaryUnmarshaler:
+		return m.UnmarshalBinary(data)
+	case binaryUnmarshaler:
+		return m.Unmarshal(data)
+	}
+	return fmt.Errorf("knet: %T does not implement encoding.BinaryUnmarshaler", v)
+}
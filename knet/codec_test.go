@@ -0,0 +1,181 @@
+// @Title codec_test.go
+// @Description 内置编解码器的往返编解码以及类型化路由 Handle 的请求解码、错误响应与响应编码的测试
+// @Author Zero - 2023/10/22 21:05:48
+
+package knet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"net"
+	"testing"
+)
+
+// sumRequest 类型化路由的测试请求
+type sumRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+// sumResponse 类型化路由的测试响应
+type sumResponse struct {
+	Sum int `json:"sum"`
+}
+
+// MarshalBinary 以两个大端序 uint32 编码，用于 BinaryCodec 的测试
+func (r *sumRequest) MarshalBinary() ([]byte, error) {
+	data := binary.BigEndian.AppendUint32(nil, uint32(r.A))
+	return binary.BigEndian.AppendUint32(data, uint32(r.B)), nil
+}
+
+// UnmarshalBinary 解码 MarshalBinary 的编码结果
+func (r *sumRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("sumRequest: invalid length")
+	}
+	r.A = int(binary.BigEndian.Uint32(data))
+	r.B = int(binary.BigEndian.Uint32(data[4:]))
+	return nil
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := map[string]kiface.ICodec{
+		"json":   NewJSONCodec(),
+		"gob":    NewGobCodec(),
+		"binary": NewBinaryCodec(),
+	}
+	for name, codec := range codecs {
+		data, err := codec.Marshal(&sumRequest{A: 3, B: 4})
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		var req sumRequest
+		if err = codec.Unmarshal(data, &req); err != nil {
+			t.Fatalf("%s: unmarshal: %v", name, err)
+		}
+		if req.A != 3 || req.B != 4 {
+			t.Fatalf("%s: got %+v", name, req)
+		}
+	}
+	// 未实现二进制编解码接口的对象
+	if _, err := NewBinaryCodec().Marshal(&sumResponse{}); err == nil {
+		t.Fatal("binary codec marshaled a type without MarshalBinary")
+	}
+	if err := NewBinaryCodec().Unmarshal(nil, &sumResponse{}); err == nil {
+		t.Fatal("binary codec unmarshaled a type without UnmarshalBinary")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	const (
+		sumID uint64 = iota + 1
+		unencodableID
+	)
+	router := NewRouter()
+	Handle(router, sumID, func(ctx kiface.IHandlerContext, req *sumRequest) (*sumResponse, error) {
+		switch {
+		case req.A < 0:
+			return nil, &ErrorReply{Code: 422, Message: "negative"}
+		case req.A == 0:
+			return nil, errors.New("zero")
+		case req.B == 0:
+			// 无响应
+			return nil, nil
+		}
+		return &sumResponse{Sum: req.A + req.B}, nil
+	})
+	// 无法编码的响应: JSON 不支持 chan
+	Handle(router, unencodableID, func(ctx kiface.IHandlerContext, req *sumRequest) (*chan int, error) {
+		ch := make(chan int)
+		return &ch, nil
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	call := func(id uint64, payload string) kiface.IMessage {
+		t.Helper()
+		reply, err := testCall(conn, NewMessage(id, []byte(payload)))
+		if err != nil {
+			t.Fatalf("request %s: %v", payload, err)
+		}
+		return reply
+	}
+	expectError := func(reply kiface.IMessage, code uint32) {
+		t.Helper()
+		e, ok := ParseErrorReply(reply)
+		if !ok || e.Code != code {
+			t.Fatalf("got id %d payload %q, want error code %d", reply.ID(), reply.Payload(), code)
+		}
+	}
+
+	// 请求与响应按服务端的编解码器(默认 JSON)编解码
+	reply := call(sumID, `{"a":3,"b":4}`)
+	var resp sumResponse
+	if err = json.Unmarshal(reply.Payload(), &resp); err != nil || reply.ID() != sumID || resp.Sum != 7 {
+		t.Fatalf("got id %d payload %q", reply.ID(), reply.Payload())
+	}
+	// 请求无法解码
+	expectError(call(sumID, `{"a":`), ErrCodeBadRequest)
+	// *ErrorReply 以其错误码响应
+	expectError(call(sumID, `{"a":-1,"b":1}`), 422)
+	// 其他错误
+	expectError(call(sumID, `{"a":0,"b":1}`), ErrCodeInternal)
+	// 无响应时不发送消息，会话继续处理后续请求
+	if _, err = conn.Write(mustPack(t, NewMessage(sumID, []byte(`{"a":1,"b":0}`)))); err != nil {
+		t.Fatal(err)
+	}
+	reply = call(sumID, `{"a":1,"b":1}`)
+	if err = json.Unmarshal(reply.Payload(), &resp); err != nil || resp.Sum != 2 {
+		t.Fatalf("got payload %q after empty response", reply.Payload())
+	}
+	// 响应无法编码属于服务端错误，处理函数返回错误，会话被关闭
+	if _, err = testCall(conn, NewMessage(unencodableID, []byte(`{}`))); !errors.Is(err, io.EOF) {
+		t.Fatalf("unencodable response got %v, want io.EOF", err)
+	}
+}
+
+func TestHandleCodec(t *testing.T) {
+	router := NewRouter()
+	Handle(router, 1, func(ctx kiface.IHandlerContext, req *sumRequest) (*sumRequest, error) {
+		return &sumRequest{A: req.A + req.B, B: req.A * req.B}, nil
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router), WithCodec(NewBinaryCodec()))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload, _ := (&sumRequest{A: 3, B: 4}).MarshalBinary()
+	reply, err := testCall(conn, NewMessage(1, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp sumRequest
+	if err = resp.UnmarshalBinary(reply.Payload()); err != nil || resp.A != 7 || resp.B != 12 {
+		t.Fatalf("got payload %x", reply.Payload())
+	}
+	// 使用服务端的编解码器解码失败
+	if reply, err = testCall(conn, NewMessage(1, []byte{1})); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := ParseErrorReply(reply); !ok || e.Code != ErrCodeBadRequest {
+		t.Fatalf("got id %d payload %q, want bad request", reply.ID(), reply.Payload())
+	}
+}
+
+// mustPack 使用 NormalPacker 封包消息
+func mustPack(t *testing.T, message kiface.IMessage) []byte {
+	t.Helper()
+	pack, err := NewNormalPacker().Pack(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
@@ -0,0 +1,263 @@
+// @Title compress_packer.go
+// @Description 消息内容压缩，包装任意消息处理器
+// @Author Zero - 2023/10/17 15:20:37
+
+package knet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"sync"
+)
+
+// Compression 压缩算法
+type Compression int
+
+const (
+	// CompressionGzip gzip 压缩
+	CompressionGzip Compression = iota
+	// CompressionZlib zlib 压缩
+	CompressionZlib
+	// CompressionDeflate deflate 压缩
+	CompressionDeflate
+)
+
+const (
+	// 默认的压缩阈值，消息内容长度达到该值才进行压缩
+	defaultCompressThreshold = 1024
+	// 所有压缩算法的标志位
+	flagCompressMask = FlagGzip | FlagZlib | FlagDeflate
+)
+
+// flag 压缩算法对应的消息标志位
+func (c Compression) flag() uint16 {
+	switch c {
+	case CompressionZlib:
+		return FlagZlib
+	case CompressionDeflate:
+		return FlagDeflate
+	default:
+		return FlagGzip
+	}
+}
+
+// compressWriter 可复用的压缩写入器
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// CompressPacker 消息内容压缩处理器，包装任意支持消息标志位的消息处理器(如 NormalPacker、ExtPacker)
+// 封包时消息内容长度达到阈值且压缩后更小时进行压缩，并在消息标志位中标记压缩算法；
+// 解包时根据标志位透明地解压，支持所有内置的压缩算法，因此通信双方可以各自选择压缩算法，无需握手协商.
+// 解压后的长度超过限制时返回 ErrFrameTooLarge，防止解压炸弹.
+// 需要为每个会话单独决定是否压缩时，可通过 WithPackerFactory 按连接(如所属的监听器)选择是否包装，
+// 客户端通过 kclient.WithPacker 使用相同的包装.
+type CompressPacker struct {
+	// 被包装的消息处理器
+	packer kiface.IPacker
+	// 压缩算法
+	compression Compression
+	// 压缩级别
+	level int
+	// 压缩阈值
+	threshold int
+	// 允许的解压后最大消息内容长度，0 表示不限制
+	maxDecompressedSize uint64
+	// 压缩写入器池
+	writers sync.Pool
+}
+
+// CompressPackerOption CompressPacker的配置注册函数
+type CompressPackerOption func(packer *CompressPacker)
+
+// WithCompression 设置压缩算法，默认为 CompressionGzip
+func WithCompression(compression Compression) CompressPackerOption {
+	return func(p *CompressPacker) {
+		p.compression = compression
+	}
+}
+
+// WithCompressLevel 设置压缩级别，取值同 compress/flate，默认为 flate.DefaultCompression
+func WithCompressLevel(level int) CompressPackerOption {
+	return func(p *CompressPacker) {
+		p.level = level
+	}
+}
+
+// WithCompressThreshold 设置压缩阈值，消息内容长度达到该值才进行压缩，默认为 1024 byte
+func WithCompressThreshold(threshold int) CompressPackerOption {
+	return func(p *CompressPacker) {
+		p.threshold = threshold
+	}
+}
+
+// WithMaxDecompressedSize 设置允许的解压后最大消息内容长度，0 表示不限制，默认为 DefaultMaxPayloadSize
+func WithMaxDecompressedSize(size uint64) CompressPackerOption {
+	return func(p *CompressPacker) {
+		p.maxDecompressedSize = size
+	}
+}
+
+// NewCompressPacker 构造函数
+// @param	packer	被包装的消息处理器，需实现 FlagsCarrier 且能够携带压缩标志位，否则压缩标志位将在传输中丢失，
+// 对端会将压缩后的内容当作原始内容处理；不满足时 panic
+func NewCompressPacker(packer kiface.IPacker, opts ...CompressPackerOption) kiface.IPacker {
+	if carrier, ok := packer.(FlagsCarrier); !ok || carrier.FlagsMask()&flagCompressMask != flagCompressMask {
+		panic(fmt.Sprintf("knet: %T cannot carry compression flags", packer))
+	}
+	p := &CompressPacker{
+		packer:              packer,
+		compression:         CompressionGzip,
+		level:               flate.DefaultCompression,
+		threshold:           defaultCompressThreshold,
+		maxDecompressedSize: DefaultMaxPayloadSize,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.writers.New = func() any {
+		return p.newWriter()
+	}
+	return p
+}
+
+// FlagsMask 压缩标志位由 CompressPacker 使用，不再对外提供
+func (p *CompressPacker) FlagsMask() uint16 {
+	return p.packer.(FlagsCarrier).FlagsMask() &^ flagCompressMask
+}
+
+// pooledPack 压缩后仍由被包装的消息处理器封包，数据包缓冲区的来源与其一致
+func (p *CompressPacker) pooledPack() bool {
+	inner, ok := p.packer.(pooledPacker)
+	return ok && inner.pooledPack()
+}
+
+// newWriter 创建压缩写入器，压缩级别无效时使用默认级别
+func (p *CompressPacker) newWriter() compressWriter {
+	switch p.compression {
+	case CompressionZlib:
+		if w, err := zlib.NewWriterLevel(nil, p.level); err == nil {
+			return w
+		}
+		return zlib.NewWriter(nil)
+	case CompressionDeflate:
+		if w, err := flate.NewWriter(nil, p.level); err == nil {
+			return w
+		}
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	default:
+		if w, err := gzip.NewWriterLevel(nil, p.level); err == nil {
+			return w
+		}
+		return gzip.NewWriter(nil)
+	}
+}
+
+// SetMaxPayloadSize 设置允许的最大消息内容长度，同时作用于解压后的长度以及被包装的消息处理器
+func (p *CompressPacker) SetMaxPayloadSize(size uint64) {
+	p.maxDecompressedSize = size
+	if limiter, ok := p.packer.(PayloadLimiter); ok {
+		limiter.SetMaxPayloadSize(size)
+	}
+}
+
+// Pack 消息打包，满足条件时压缩消息内容
+// 不会修改传入的消息，同一消息可以安全地广播给多个会话
+func (p *CompressPacker) Pack(message kiface.IMessage) ([]byte, error) {
+	header, ok := message.(kiface.IMessageHeader)
+	if !ok || len(message.Payload()) < p.threshold || header.Flags()&flagCompressMask != 0 {
+		return p.packer.Pack(message)
+	}
+	compressed, err := p.compress(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(message.Payload()) {
+		// 压缩没有收益，发送原始内容
+		return p.packer.Pack(message)
+	}
+	return p.packer.Pack(&Message{
+		len:      uint64(len(compressed)),
+		id:       message.ID(),
+		seq:      message.Seq(),
+		flags:    header.Flags() | p.compression.flag(),
+		metadata: header.Metadata(),
+		payload:  compressed,
+	})
+}
+
+// compress 压缩消息内容
+func (p *CompressPacker) compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := p.writers.Get().(compressWriter)
+	defer p.writers.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnPack 消息解包，根据消息标志位解压消息内容，并清除压缩标志位
+func (p *CompressPacker) UnPack(reader io.Reader) (kiface.IMessage, error) {
+	message, err := p.packer.UnPack(reader)
+	if err != nil {
+		return nil, err
+	}
+	header, ok := message.(kiface.IMessageHeader)
+	if !ok || header.Flags()&flagCompressMask == 0 {
+		return message, nil
+	}
+	payload, err := p.decompress(header.Flags(), message.Payload())
+	// 压缩内容已不再需要，归还缓冲区
+	message.Release()
+	if err != nil {
+		return nil, err
+	}
+	header.PutFlags(header.Flags() &^ flagCompressMask)
+	message.PutPayload(payload)
+	message.PutLen(uint64(len(payload)))
+	return message, nil
+}
+
+// decompress 解压消息内容，解压后的长度超过限制时返回 ErrFrameTooLarge
+func (p *CompressPacker) decompress(flags uint16, data []byte) ([]byte, error) {
+	src := bytes.NewReader(data)
+	var r io.ReadCloser
+	var err error
+	switch {
+	case flags&FlagGzip != 0:
+		r, err = gzip.NewReader(src)
+	case flags&FlagZlib != 0:
+		r, err = zlib.NewReader(src)
+	default:
+		r = flate.NewReader(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedFrame, err.Error())
+	}
+	defer r.Close()
+	var limited io.Reader = r
+	if p.maxDecompressedSize > 0 {
+		// 多读取 1 byte 用于判断是否超过限制
+		limited = io.LimitReader(r, int64(p.maxDecompressedSize)+1)
+	}
+	payload, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedFrame, err.Error())
+	}
+	if err = checkPayloadSize(uint64(len(payload)), p.maxDecompressedSize); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,115 @@
+// @Title compress_packer_test.go
+// @Description CompressPacker 的压缩编解码以及通过 WithPackerFactory 按连接选择压缩的测试
+// @Author Zero - 2023/10/22 16:42:09
+
+package knet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompressPackerRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("snapshot "), 512)
+	for name, inner := range map[string]kiface.IPacker{"normal": NewNormalPacker(), "ext": NewExtPacker()} {
+		for _, compression := range []Compression{CompressionGzip, CompressionZlib, CompressionDeflate} {
+			packer := NewCompressPacker(inner, WithCompression(compression))
+			pack, err := packer.Pack(NewMessage(1, payload))
+			if err != nil {
+				t.Fatalf("%s/%d: pack: %v", name, compression, err)
+			}
+			if len(pack) >= len(payload) {
+				t.Fatalf("%s/%d: payload not compressed", name, compression)
+			}
+			got, err := packer.UnPack(bytes.NewReader(pack))
+			if err != nil {
+				t.Fatalf("%s/%d: unpack: %v", name, compression, err)
+			}
+			if !bytes.Equal(got.Payload(), payload) || got.(kiface.IMessageHeader).Flags()&flagCompressMask != 0 {
+				t.Fatalf("%s/%d: payload or flags not restored", name, compression)
+			}
+		}
+	}
+}
+
+func TestCompressPackerRejectsPackerWithoutFlags(t *testing.T) {
+	for name, inner := range map[string]kiface.IPacker{
+		"varint":   NewVarintPacker(),
+		"compress": NewCompressPacker(NewNormalPacker()),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: NewCompressPacker accepted a packer that cannot carry compression flags", name)
+				}
+			}()
+			NewCompressPacker(inner)
+		}()
+	}
+}
+
+func TestCompressPackerFactory(t *testing.T) {
+	// 仅 "compressed" 监听器接收的连接使用压缩，"rejected" 监听器的连接被关闭
+	factory := func(conn net.Conn) (kiface.IPacker, error) {
+		switch ListenerName(conn) {
+		case "compressed":
+			return NewCompressPacker(NewNormalPacker(), WithCompressThreshold(64)), nil
+		case "rejected":
+			return nil, errors.New("rejected")
+		}
+		return NewNormalPacker(), nil
+	}
+	server, _ := startTestServer(t, ListenerConfig{Name: "plain"},
+		WithHandler(&testEchoHandler{}),
+		WithPackerFactory(factory),
+		WithListener(ListenerConfig{Name: "compressed", Address: "127.0.0.1:0"}),
+		WithListener(ListenerConfig{Name: "rejected", Address: "127.0.0.1:0"}),
+	)
+	payload := bytes.Repeat([]byte("snapshot "), 64)
+	for i, compressed := range []bool{false, true} {
+		conn, err := net.Dial("tcp", server.listeners[i].Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+		// 客户端使用与服务端相同的消息处理器
+		packer := NewNormalPacker()
+		if compressed {
+			packer = NewCompressPacker(NewNormalPacker(), WithCompressThreshold(64))
+		}
+		pack, err := packer.Pack(NewMessage(1, payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write(pack); err != nil {
+			t.Fatal(err)
+		}
+		// 以不解压的方式读取响应，检查服务端是否压缩
+		reply, err := NewNormalPacker().UnPack(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		flags := reply.(kiface.IMessageHeader).Flags() & flagCompressMask
+		if (flags != 0) != compressed {
+			t.Fatalf("listener %d: compression flags %x, want compressed %v", i, flags, compressed)
+		}
+		if !compressed && string(reply.Payload()) != "echo:"+string(payload) {
+			t.Fatalf("listener %d: got %q", i, reply.Payload())
+		}
+	}
+	conn, err := net.Dial("tcp", server.listeners[2].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 连接在读取请求前被关闭，可能表现为 EOF 或连接重置
+	if reply, err := testCall(conn, NewMessage(1, payload)); err == nil {
+		t.Fatalf("factory error: connection not closed, got reply %d", reply.ID())
+	}
+}
@@ -20,6 +20,8 @@ const (
 	defaultHost = "0.0.0.0"
 	// 默认服务端口
 	defaultPort = 9780
+	// 默认网络类型
+	defaultNetwork = "tcp"
 )
 
 // 配置文件路径
@@ -36,6 +38,56 @@ type serverConfig struct {
 	Host string `json:"host"`
 	// 服务端口
 	Port int `json:"port"`
+	// 网络类型: "tcp" | "unix"，默认为 "tcp"
+	Network string `json:"network"`
+	// 监听地址，设置后优先于 host 与 port；network 为 "unix" 时为套接字文件路径
+	Address string `json:"address"`
+	// Unix 域套接字文件的权限，八进制字符串，如 "0660"
+	SocketMode string `json:"socket_mode"`
+	// 最大连接数，0 表示不限制
+	MaxConn int `json:"max_conn"`
+	// TLS配置，为空时使用明文传输
+	TLS *tlsConfig `json:"tls"`
+	// WebSocket配置，设置后以 WebSocket 协议接收连接
+	WebSocket *webSocketConfig `json:"websocket"`
+	// 监听器列表，设置后忽略 network、address、host、port、tls 以及 websocket 配置
+	Listeners []*listenerConfig `json:"listeners"`
+}
+
+// listenerConfig 监听器配置属性实体
+type listenerConfig struct {
+	// 监听器名称，为空时使用 address
+	Name string `json:"name"`
+	// 网络类型: "tcp" | "unix"，默认为 "tcp"
+	Network string `json:"network"`
+	// 监听地址，network 为 "unix" 时为套接字文件路径
+	Address string `json:"address"`
+	// Unix 域套接字文件的权限，八进制字符串，如 "0660"
+	SocketMode string `json:"socket_mode"`
+	// TLS配置，为空时使用明文传输
+	TLS *tlsConfig `json:"tls"`
+	// WebSocket配置，设置后以 WebSocket 协议接收连接
+	WebSocket *webSocketConfig `json:"websocket"`
+}
+
+// webSocketConfig WebSocket配置属性实体
+type webSocketConfig struct {
+	// 允许升级的请求路径，如 "/ws"，为空时不限制
+	Path string `json:"path"`
+}
+
+// tlsConfig TLS配置属性实体
+type tlsConfig struct {
+	// 服务端证书文件路径
+	CertFile string `json:"cert_file"`
+	// 服务端私钥文件路径
+	KeyFile string `json:"key_file"`
+	// 客户端CA证书文件路径，设置后开启双向认证，要求客户端提供由该CA签发的证书
+	ClientCAFile string `json:"client_ca_file"`
+	// 最低TLS版本: "1.0" | "1.1" | "1.2" | "1.3"，默认为 "1.2"
+	MinVersion string `json:"min_version"`
+	// 允许的加密套件名称，如 "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"，为空时使用默认套件(TLS1.3不可配置)
+	CipherSuites []string `json:"cipher_suites"`
 }
 
 // 解析命令行参数，获取服务的配置文件
@@ -46,9 +98,10 @@ func init() {
 // 创建默认的服务配置
 func newDefaultConfig() *serverConfig {
 	return &serverConfig{
-		Name: defaultName,
-		Host: defaultHost,
-		Port: defaultPort,
+		Name:    defaultName,
+		Host:    defaultHost,
+		Port:    defaultPort,
+		Network: defaultNetwork,
 	}
 }
 
@@ -79,4 +132,7 @@ func loadConfigs() {
 	if configs.Port == 0 {
 		configs.Port = defaultPort
 	}
+	if len(configs.Network) == 0 {
+		configs.Network = defaultNetwork
+	}
 }
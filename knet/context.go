@@ -16,6 +16,14 @@ type HandlerContext struct {
 	s kiface.ISession
 	// 可处理的数据消息
 	message kiface.IMessage
+	// 处理链(中间件 + 处理函数)
+	handlers []kiface.HandlerFunc
+	// 处理链当前执行到的位置
+	index int
+	// 处理链是否已中止
+	aborted bool
+	// 消息内容编解码器
+	codec kiface.ICodec
 }
 
 func (hc *HandlerContext) Put(key, value any) {
@@ -32,6 +40,8 @@ func NewHandlerContext(s kiface.ISession, m kiface.IMessage, ctx context.Context
 		s:       s,
 		message: m,
 		c:       ctx,
+		index:   -1,
+		codec:   NewJSONCodec(),
 	}
 }
 
@@ -44,3 +54,64 @@ func (hc *HandlerContext) GetSession() kiface.ISession {
 func (hc *HandlerContext) GetMessage() kiface.IMessage {
 	return hc.message
 }
+
+// Next 执行处理链中后续的中间件与处理函数
+// 中间件未调用 Next 且未 Abort 时，中间件返回后会继续执行后续的处理函数
+// 任意处理函数返回错误时，处理链终止并返回该错误
+func (hc *HandlerContext) Next() error {
+	hc.index++
+	for hc.index < len(hc.handlers) && !hc.aborted {
+		if err := hc.handlers[hc.index](hc); err != nil {
+			return err
+		}
+		hc.index++
+	}
+	return nil
+}
+
+// Abort 中止处理链
+func (hc *HandlerContext) Abort() {
+	hc.aborted = true
+}
+
+// IsAborted 处理链是否已中止
+func (hc *HandlerContext) IsAborted() bool {
+	return hc.aborted
+}
+
+// Reply 响应本次请求，响应消息的ID以及序列号与请求相同
+func (hc *HandlerContext) Reply(payload []byte) error {
+	return hc.reply(NewMessage(hc.message.ID(), payload))
+}
+
+// ReplyError 以 MsgIDError 错误消息响应本次请求
+func (hc *HandlerContext) ReplyError(code uint32, msg string) error {
+	return hc.reply(NewErrorMessage(code, msg))
+}
+
+// Bind 将消息内容解码至 v
+func (hc *HandlerContext) Bind(v any) error {
+	return hc.codec.Unmarshal(hc.message.Payload(), v)
+}
+
+// ReplyObject 编码 v，并以编码结果响应本次请求
+func (hc *HandlerContext) ReplyObject(v any) error {
+	payload, err := hc.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return hc.Reply(payload)
+}
+
+// reply 为响应消息设置请求的序列号，并且添加至会话的写队列
+func (hc *HandlerContext) reply(message kiface.IMessage) error {
+	message.PutSeq(hc.message.Seq())
+	return hc.s.Send(message)
+}
+
+// run 从头执行处理链
+func (hc *HandlerContext) run(handlers []kiface.HandlerFunc) error {
+	hc.handlers = handlers
+	hc.index = -1
+	return hc.Next()
+}
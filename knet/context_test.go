@@ -0,0 +1,52 @@
+// @Title context_test.go
+// @Description 处理上下文 Reply / ReplyError 响应的消息ID与序列号的测试
+// @Author Zero - 2023/10/22 20:06:31
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+)
+
+func TestContextReply(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute(1, func(ctx kiface.IHandlerContext) error {
+		return ctx.Reply(append([]byte("reply:"), ctx.GetMessage().Payload()...))
+	})
+	router.AddRoute(2, func(ctx kiface.IHandlerContext) error {
+		return ctx.ReplyError(ErrCodeBadRequest, "bad request")
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Reply 沿用请求的消息ID以及序列号
+	request := NewMessage(1, []byte("hello"))
+	request.PutSeq(7)
+	reply, err := testCall(conn, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.ID() != 1 || reply.Seq() != 7 || string(reply.Payload()) != "reply:hello" {
+		t.Fatalf("got id %d seq %d payload %q", reply.ID(), reply.Seq(), reply.Payload())
+	}
+
+	// ReplyError 以 MsgIDError 响应，携带请求的序列号以及错误码
+	request = NewMessage(2, nil)
+	request.PutSeq(8)
+	if reply, err = testCall(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ID() != MsgIDError || reply.Seq() != 8 {
+		t.Fatalf("got id %d seq %d, want MsgIDError seq 8", reply.ID(), reply.Seq())
+	}
+	e, ok := ParseErrorReply(reply)
+	if !ok || e.Code != ErrCodeBadRequest || e.Message != "bad request" {
+		t.Fatalf("got error reply %+v ok %v", e, ok)
+	}
+}
@@ -4,4 +4,33 @@
 
 package knet
 
-var ()
+import "errors"
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("knet: session closed")
+	// ErrServerClosed 服务端已关闭
+	ErrServerClosed = errors.New("knet: server closed")
+	// ErrFieldOverflow 字段的值超过定长编码的表示范围
+	ErrFieldOverflow = errors.New("knet: field overflows fixed width")
+	// ErrFrameTooLarge 消息内容长度超过允许的最大值
+	ErrFrameTooLarge = errors.New("knet: frame too large")
+	// ErrAuthFailed 会话认证失败
+	ErrAuthFailed = errors.New("knet: authentication failed")
+	// ErrAuthTimeout 会话认证超时
+	ErrAuthTimeout = errors.New("knet: authentication timeout")
+	// ErrWebSocketHandshake WebSocket 升级握手失败
+	ErrWebSocketHandshake = errors.New("knet: websocket handshake failed")
+	// ErrWebSocketProtocol 对端发送的 WebSocket 帧不符合协议
+	ErrWebSocketProtocol = errors.New("knet: websocket protocol error")
+	// ErrUnsupportedVersion 不支持的帧格式版本
+	ErrUnsupportedVersion = errors.New("knet: unsupported frame version")
+	// ErrMalformedFrame 数据包格式错误
+	ErrMalformedFrame = errors.New("knet: malformed frame")
+	// ErrSendTimeout 会话发送队列已满，等待超时
+	ErrSendTimeout = errors.New("knet: send timeout")
+	// ErrQueueFull 会话发送队列已满，消息被丢弃
+	ErrQueueFull = errors.New("knet: write queue full")
+	// ErrSlowConsumer 会话发送队列已满，会话因消费过慢被关闭
+	ErrSlowConsumer = errors.New("knet: slow consumer")
+)
@@ -0,0 +1,173 @@
+// @Title ext_packer.go
+// @Description 支持扩展头部(标志位、序列号、元数据)的消息处理器
+// @Author Zero - 2023/10/17 09:48:26
+
+package knet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	// ExtVersion 扩展帧格式的当前版本
+	ExtVersion uint8 = 1
+
+	// 扩展帧固定头部的字节数: Version(1) + Flags(2) + ID(8) + Seq(8) + MetaLen(2) + Len(4)
+	extHeaderSize = 25
+	// 元数据Key长度所占字节数
+	extMetaKeyByteSize = 1
+	// 元数据Value长度所占字节数
+	extMetaValueByteSize = 2
+)
+
+// ExtPacker 扩展帧格式的消息处理器，完整支持 kiface.IMessageHeader
+// 数据包格式(大端序): [Version|Flags|ID|Seq|MetaLen|Len|Metadata|Payload]
+//
+//	Version	1 byte	帧格式版本，当前为 ExtVersion，不支持的版本解包时返回 ErrUnsupportedVersion
+//	Flags	2 byte	消息标志位
+//	ID		8 byte	消息ID
+//	Seq		8 byte	消息序列号
+//	MetaLen	2 byte	元数据的字节数
+//	Len		4 byte	消息内容长度
+//	Metadata	多个 [KeyLen(1)|Key|ValueLen(2)|Value]，按 Key 排序
+//
+// 与 NormalPacker 的帧格式不兼容，客户端与服务端需使用相同的消息处理器.
+type ExtPacker struct {
+	// 允许的最大消息内容长度，0 表示不限制
+	maxPayloadSize uint64
+}
+
+// NewExtPacker 构造函数
+func NewExtPacker() kiface.IPacker {
+	return &ExtPacker{maxPayloadSize: DefaultMaxPayloadSize}
+}
+
+// SetMaxPayloadSize 设置允许的最大消息内容长度，0 表示不限制
+func (packer *ExtPacker) SetMaxPayloadSize(size uint64) {
+	packer.maxPayloadSize = size
+}
+
+// FlagsMask 可携带完整的 16 位消息标志位
+func (packer *ExtPacker) FlagsMask() uint16 {
+	return math.MaxUint16
+}
+
+// pooledPack 数据包缓冲区取自缓冲池
+func (packer *ExtPacker) pooledPack() bool {
+	return true
+}
+
+// Pack 消息打包
+func (packer *ExtPacker) Pack(message kiface.IMessage) ([]byte, error) {
+	if message.Len() > math.MaxUint32 {
+		return nil, ErrFieldOverflow
+	}
+	var flags uint16
+	var metadata map[string]string
+	if header, ok := message.(kiface.IMessageHeader); ok {
+		flags = header.Flags()
+		metadata = header.Metadata()
+	}
+	meta, err := encodeMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	packs := GetBuffer(extHeaderSize + len(meta) + len(message.Payload()))[:extHeaderSize]
+	packs[0] = ExtVersion
+	binary.BigEndian.PutUint16(packs[1:3], flags)
+	binary.BigEndian.PutUint64(packs[3:11], message.ID())
+	binary.BigEndian.PutUint64(packs[11:19], message.Seq())
+	binary.BigEndian.PutUint16(packs[19:21], uint16(len(meta)))
+	binary.BigEndian.PutUint32(packs[21:25], uint32(message.Len()))
+	packs = append(packs, meta...)
+	packs = append(packs, message.Payload()...)
+	return packs, nil
+}
+
+// UnPack 消息解包
+func (packer *ExtPacker) UnPack(reader io.Reader) (kiface.IMessage, error) {
+	header := getBuffer(extHeaderSize)
+	defer putBuffer(header)
+	buf := *header
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != ExtVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, buf[0])
+	}
+	flags := binary.BigEndian.Uint16(buf[1:3])
+	id := binary.BigEndian.Uint64(buf[3:11])
+	seq := binary.BigEndian.Uint64(buf[11:19])
+	metaLen := int(binary.BigEndian.Uint16(buf[19:21]))
+	lens := uint64(binary.BigEndian.Uint32(buf[21:25]))
+	// 在分配内存前校验消息内容长度
+	if err := checkPayloadSize(lens, packer.maxPayloadSize); err != nil {
+		return nil, err
+	}
+	buffer := getBuffer(metaLen + int(lens))
+	body := *buffer
+	if _, err := io.ReadFull(reader, body); err != nil {
+		putBuffer(buffer)
+		return nil, err
+	}
+	message := newPooledMessage(id, body[metaLen:], buffer)
+	message.PutSeq(seq)
+	message.PutFlags(flags)
+	if err := decodeMetadata(body[:metaLen], message); err != nil {
+		message.Release()
+		return nil, err
+	}
+	return message, nil
+}
+
+// encodeMetadata 编码元数据，按 Key 排序以保证编码结果稳定
+func encodeMetadata(metadata map[string]string) ([]byte, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var meta []byte
+	for _, key := range keys {
+		value := metadata[key]
+		if len(key) > math.MaxUint8 || len(value) > math.MaxUint16 {
+			return nil, ErrFieldOverflow
+		}
+		meta = append(meta, uint8(len(key)))
+		meta = append(meta, key...)
+		meta = binary.BigEndian.AppendUint16(meta, uint16(len(value)))
+		meta = append(meta, value...)
+	}
+	if len(meta) > math.MaxUint16 {
+		return nil, ErrFieldOverflow
+	}
+	return meta, nil
+}
+
+// decodeMetadata 解码元数据并设置到消息的扩展头部
+func decodeMetadata(meta []byte, header kiface.IMessageHeader) error {
+	for len(meta) > 0 {
+		keyLen := int(meta[0])
+		meta = meta[extMetaKeyByteSize:]
+		if len(meta) < keyLen+extMetaValueByteSize {
+			return ErrMalformedFrame
+		}
+		key := string(meta[:keyLen])
+		valueLen := int(binary.BigEndian.Uint16(meta[keyLen:]))
+		meta = meta[keyLen+extMetaValueByteSize:]
+		if len(meta) < valueLen {
+			return ErrMalformedFrame
+		}
+		header.PutMeta(key, string(meta[:valueLen]))
+		meta = meta[valueLen:]
+	}
+	return nil
+}
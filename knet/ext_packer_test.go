@@ -0,0 +1,117 @@
+// @Title ext_packer_test.go
+// @Description ExtPacker 的扩展头部编解码、帧格式版本校验以及异常帧的测试
+// @Author Zero - 2023/10/22 20:57:13
+
+package knet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"strings"
+	"testing"
+)
+
+func TestExtPackerRoundTrip(t *testing.T) {
+	packer := NewExtPacker()
+	cases := []struct {
+		name     string
+		payload  []byte
+		flags    uint16
+		metadata map[string]string
+	}{
+		{name: "plain"},
+		{name: "flags", payload: []byte("hello"), flags: 0xA5F0},
+		{name: "metadata", payload: []byte("hello"), flags: 1, metadata: map[string]string{
+			"trace-id": "abc123", "tenant": "kinx", "empty": "",
+		}},
+	}
+	for _, c := range cases {
+		message := NewMessage(42, c.payload).(*Message)
+		message.PutSeq(1 << 40)
+		message.PutFlags(c.flags)
+		for key, value := range c.metadata {
+			message.PutMeta(key, value)
+		}
+		pack, err := packer.Pack(message)
+		if err != nil {
+			t.Fatalf("%s: pack: %v", c.name, err)
+		}
+		if pack[0] != ExtVersion {
+			t.Fatalf("%s: frame version %d, want %d", c.name, pack[0], ExtVersion)
+		}
+		got, err := packer.UnPack(bytes.NewReader(pack))
+		if err != nil {
+			t.Fatalf("%s: unpack: %v", c.name, err)
+		}
+		header := got.(kiface.IMessageHeader)
+		if got.ID() != 42 || got.Seq() != 1<<40 || header.Flags() != c.flags || !bytes.Equal(got.Payload(), c.payload) {
+			t.Fatalf("%s: got id %d seq %d flags %x payload %q", c.name, got.ID(), got.Seq(), header.Flags(), got.Payload())
+		}
+		if metadata := header.Metadata(); len(metadata) != len(c.metadata) {
+			t.Fatalf("%s: got metadata %v, want %v", c.name, metadata, c.metadata)
+		}
+		for key, value := range c.metadata {
+			if v, ok := header.Meta(key); !ok || v != value {
+				t.Fatalf("%s: meta %q = %q, want %q", c.name, key, v, value)
+			}
+		}
+	}
+}
+
+func TestExtPackerMetadataStable(t *testing.T) {
+	packer := NewExtPacker()
+	first := NewMessage(1, nil).(*Message)
+	second := NewMessage(1, nil).(*Message)
+	// 元数据按 Key 排序编码，与设置顺序无关
+	for _, key := range []string{"a", "b", "c"} {
+		first.PutMeta(key, key)
+	}
+	for _, key := range []string{"c", "a", "b"} {
+		second.PutMeta(key, key)
+	}
+	a, _ := packer.Pack(first)
+	b, _ := packer.Pack(second)
+	if !bytes.Equal(a, b) {
+		t.Fatal("metadata encoding depends on insertion order")
+	}
+}
+
+func TestExtPackerErrors(t *testing.T) {
+	packer := NewExtPacker()
+
+	// 不支持的帧格式版本
+	pack, _ := packer.Pack(NewMessage(1, []byte("hello")))
+	frame := append([]byte{}, pack...)
+	frame[0] = ExtVersion + 1
+	if _, err := packer.UnPack(bytes.NewReader(frame)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("unsupported version got %v, want ErrUnsupportedVersion", err)
+	}
+
+	// 元数据的长度字段超出元数据
+	message := NewMessage(1, nil).(*Message)
+	message.PutMeta("key", "value")
+	pack, _ = packer.Pack(message)
+	frame = append([]byte{}, pack...)
+	frame[extHeaderSize] = 200
+	if _, err := packer.UnPack(bytes.NewReader(frame)); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("malformed metadata got %v, want ErrMalformedFrame", err)
+	}
+
+	// 元数据Key超过 255 字节
+	message = NewMessage(1, nil).(*Message)
+	message.PutMeta(strings.Repeat("k", 256), "value")
+	if _, err := packer.Pack(message); !errors.Is(err, ErrFieldOverflow) {
+		t.Fatalf("long meta key got %v, want ErrFieldOverflow", err)
+	}
+
+	// 超过长度限制的消息内容
+	packer.(PayloadLimiter).SetMaxPayloadSize(4)
+	if _, err := packer.UnPack(bytes.NewReader(pack)); err != nil {
+		t.Fatalf("payload within limit: %v", err)
+	}
+	pack, _ = NewExtPacker().Pack(NewMessage(1, []byte("hello")))
+	if _, err := packer.UnPack(bytes.NewReader(pack)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("large payload got %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -0,0 +1,203 @@
+// @Title group.go
+// @Description 会话分组实现
+// @Author Zero - 2023/10/4 15:26:40
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"sync"
+)
+
+// trySender 支持非阻塞发送的会话
+type trySender interface {
+	// TrySend 尝试将消息添加至发送队列，队列已满时立即返回false
+	TrySend(message kiface.IMessage) bool
+}
+
+// Group 会话分组
+type Group struct {
+	// 分组名称
+	name string
+	// 分组内的会话，key为会话ID
+	members map[uint32]kiface.ISession
+	// members 的读写锁
+	lock sync.RWMutex
+}
+
+// newGroup 创建分组
+func newGroup(name string) *Group {
+	return &Group{
+		name:    name,
+		members: make(map[uint32]kiface.ISession),
+	}
+}
+
+// Name 获取分组名称
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Count 获取分组内的会话数量
+func (g *Group) Count() int {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return len(g.members)
+}
+
+// Has 会话是否在分组内
+func (g *Group) Has(sessionID uint32) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	_, ok := g.members[sessionID]
+	return ok
+}
+
+// Range 遍历分组内的会话，遍历的是成员的快照
+func (g *Group) Range(fn func(session kiface.ISession) bool) {
+	g.lock.RLock()
+	members := make([]kiface.ISession, 0, len(g.members))
+	for _, session := range g.members {
+		members = append(members, session)
+	}
+	g.lock.RUnlock()
+	for _, session := range members {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// Broadcast 向分组内的所有会话发送消息
+// 发送队列已满的慢速会话按溢出策略处理(默认丢弃本条消息)，不会阻塞其他成员
+func (g *Group) Broadcast(message kiface.IMessage) int {
+	delivered := 0
+	g.Range(func(session kiface.ISession) bool {
+		if trySend(session, message) {
+			delivered++
+		}
+		return true
+	})
+	return delivered
+}
+
+// trySend 以不阻塞调用方的方式向会话发送消息，返回消息是否加入了发送队列
+// 不支持非阻塞发送的会话，在新的协程中发送
+func trySend(session kiface.ISession, message kiface.IMessage) bool {
+	if sender, ok := session.(trySender); ok {
+		return sender.TrySend(message)
+	}
+	go func() { _ = session.Send(message) }()
+	return true
+}
+
+// add 添加成员，返回是否为新加入
+func (g *Group) add(session kiface.ISession) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if _, ok := g.members[session.GetSessionID()]; ok {
+		return false
+	}
+	g.members[session.GetSessionID()] = session
+	return true
+}
+
+// remove 移除成员，返回是否移除成功以及分组是否已为空
+func (g *Group) remove(session kiface.ISession) (bool, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s, ok := g.members[session.GetSessionID()]; !ok || s != session {
+		return false, len(g.members) == 0
+	}
+	delete(g.members, session.GetSessionID())
+	return true, len(g.members) == 0
+}
+
+// GroupManager 会话分组管理器
+// 分组在第一个会话加入时创建，最后一个会话离开时删除.
+type GroupManager struct {
+	// 所有分组，key为分组名称
+	groups map[string]*Group
+	// groups 的读写锁
+	lock sync.RWMutex
+	// 会话加入分组的回调函数
+	onJoin func(group kiface.IGroup, session kiface.ISession)
+	// 会话离开分组的回调函数
+	onLeave func(group kiface.IGroup, session kiface.ISession)
+}
+
+// NewGroupManager 创建分组管理器
+func NewGroupManager() kiface.IGroupManager {
+	return &GroupManager{
+		groups: make(map[string]*Group),
+	}
+}
+
+// Get 根据名称获取分组
+func (gm *GroupManager) Get(name string) (kiface.IGroup, bool) {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+	group, ok := gm.groups[name]
+	if !ok {
+		return nil, false
+	}
+	return group, true
+}
+
+// Join 将会话加入分组，分组不存在时自动创建
+func (gm *GroupManager) Join(name string, session kiface.ISession) {
+	gm.lock.Lock()
+	group, ok := gm.groups[name]
+	if !ok {
+		group = newGroup(name)
+		gm.groups[name] = group
+	}
+	joined := group.add(session)
+	gm.lock.Unlock()
+	if joined && gm.onJoin != nil {
+		gm.onJoin(group, session)
+	}
+}
+
+// Leave 将会话移出分组，分组为空时自动删除
+func (gm *GroupManager) Leave(name string, session kiface.ISession) {
+	gm.lock.Lock()
+	group, ok := gm.groups[name]
+	if !ok {
+		gm.lock.Unlock()
+		return
+	}
+	left, empty := group.remove(session)
+	if empty {
+		delete(gm.groups, name)
+	}
+	gm.lock.Unlock()
+	if left && gm.onLeave != nil {
+		gm.onLeave(group, session)
+	}
+}
+
+// Range 遍历所有分组
+func (gm *GroupManager) Range(fn func(group kiface.IGroup) bool) {
+	gm.lock.RLock()
+	groups := make([]kiface.IGroup, 0, len(gm.groups))
+	for _, group := range gm.groups {
+		groups = append(groups, group)
+	}
+	gm.lock.RUnlock()
+	for _, group := range groups {
+		if !fn(group) {
+			return
+		}
+	}
+}
+
+// OnJoin 设置会话加入分组的回调函数，需在服务启动前设置
+func (gm *GroupManager) OnJoin(fn func(group kiface.IGroup, session kiface.ISession)) {
+	gm.onJoin = fn
+}
+
+// OnLeave 设置会话离开分组的回调函数，需在服务启动前设置
+func (gm *GroupManager) OnLeave(fn func(group kiface.IGroup, session kiface.ISession)) {
+	gm.onLeave = fn
+}
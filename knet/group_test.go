@@ -0,0 +1,144 @@
+// @Title group_test.go
+// @Description 会话分组的加入/离开回调、会话关闭时自动离开以及广播期间成员关闭的测试
+// @Author Zero - 2023/10/22 20:40:18
+
+package knet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"sync"
+	"testing"
+)
+
+// membershipRecorder 记录分组回调
+type membershipRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *membershipRecorder) record(event string) func(group kiface.IGroup, session kiface.ISession) {
+	return func(group kiface.IGroup, session kiface.ISession) {
+		r.lock.Lock()
+		r.events = append(r.events, fmt.Sprintf("%s %s %d", event, group.Name(), session.GetSessionID()))
+		r.lock.Unlock()
+	}
+}
+
+func (r *membershipRecorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestGroupMembership(t *testing.T) {
+	server := newMemberServer(t)
+	recorder := &membershipRecorder{}
+	groups := server.GetGroupManager()
+	groups.OnJoin(recorder.record("join"))
+	groups.OnLeave(recorder.record("leave"))
+	first := newMemberSession(t, server, 1)
+	second := newMemberSession(t, server, 2)
+
+	for _, session := range []*NormalSession{first, second, first} {
+		if err := session.Join("room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重复加入不会再次回调
+	if events := recorder.take(); len(events) != 2 || events[0] != "join room 1" || events[1] != "join room 2" {
+		t.Fatalf("join events %v", events)
+	}
+	group, ok := groups.Get("room")
+	if !ok || group.Count() != 2 {
+		t.Fatal("room not created with two members")
+	}
+
+	first.Leave("room")
+	first.Leave("room")
+	if events := recorder.take(); len(events) != 1 || events[0] != "leave room 1" {
+		t.Fatalf("leave events %v", events)
+	}
+	// 最后一个成员关闭时自动离开分组，分组随之删除
+	second.Stop()
+	if events := recorder.take(); len(events) != 1 || events[0] != "leave room 2" {
+		t.Fatalf("stop events %v", events)
+	}
+	if _, ok = groups.Get("room"); ok {
+		t.Fatal("empty room not removed")
+	}
+	// 已关闭的会话无法加入分组
+	if err := second.Join("room"); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("join after stop got %v, want ErrSessionClosed", err)
+	}
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("closed session triggered %v", events)
+	}
+}
+
+func TestGroupBroadcast(t *testing.T) {
+	server := newMemberServer(t)
+	members := []*NormalSession{
+		newMemberSession(t, server, 1),
+		newMemberSession(t, server, 2),
+		newMemberSession(t, server, 3),
+	}
+	outsider := newMemberSession(t, server, 4)
+	for _, member := range members {
+		if err := member.Join("room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group, _ := server.GetGroupManager().Get("room")
+	if delivered := group.Broadcast(NewMessage(1, nil)); delivered != 3 {
+		t.Fatalf("broadcast delivered %d, want 3", delivered)
+	}
+	for i, member := range members {
+		if len(member.outChannel) != 1 {
+			t.Fatalf("member %d queued %d messages, want 1", i+1, len(member.outChannel))
+		}
+	}
+	if len(outsider.outChannel) != 0 {
+		t.Fatal("broadcast reached a session outside the group")
+	}
+}
+
+func TestGroupStopDuringBroadcast(t *testing.T) {
+	server := newMemberServer(t, WithWriteQueue(1024, OverflowDropNewest))
+	members := make([]*NormalSession, 8)
+	for i := range members {
+		members[i] = newMemberSession(t, server, uint32(i+1))
+		if err := members[i].Join("room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group, _ := server.GetGroupManager().Get("room")
+	// 持续广播的同时逐个关闭成员，广播不会阻塞，也不会向已离开的成员发送
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			group.Broadcast(NewMessage(1, nil))
+		}
+	}()
+	for _, member := range members[:len(members)-1] {
+		member.Stop()
+	}
+	close(done)
+	<-stopped
+	if group.Count() != 1 || !group.Has(members[len(members)-1].ID) {
+		t.Fatalf("group count %d after stopping members, want only the last member", group.Count())
+	}
+	if delivered := group.Broadcast(NewMessage(2, nil)); delivered != 1 {
+		t.Fatalf("broadcast after stop delivered %d, want 1", delivered)
+	}
+}
@@ -0,0 +1,135 @@
+// @Title heartbeat.go
+// @Description 应用层心跳以及会话关闭原因
+// @Author Zero - 2023/10/19 09:37:20
+
+package knet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"math"
+	"time"
+)
+
+// 会话关闭原因的错误码
+const (
+	// CloseIdleTimeout 会话空闲超时
+	CloseIdleTimeout uint32 = iota + 1
+	// CloseHeartbeatTimeout 连续多次未收到心跳响应
+	CloseHeartbeatTimeout
+)
+
+// CloseReason 会话关闭原因，服务端关闭会话前通过 MsgIDClose 消息通知客户端
+type CloseReason struct {
+	// 错误码
+	Code uint32
+	// 关闭原因
+	Reason string
+}
+
+func (c *CloseReason) Error() string {
+	return fmt.Sprintf("knet: session closed %d: %s", c.Code, c.Reason)
+}
+
+// NewCloseMessage 构建会话关闭原因消息
+// 消息ID为 MsgIDClose，消息内容为 [Code|Reason]，Code 为大端序的 uint32
+func NewCloseMessage(code uint32, reason string) kiface.IMessage {
+	payload := make([]byte, errorCodeByteSize, errorCodeByteSize+len(reason))
+	binary.BigEndian.PutUint32(payload, code)
+	return NewMessage(MsgIDClose, append(payload, reason...))
+}
+
+// ParseCloseReason 解析会话关闭原因消息，非关闭原因消息返回false
+func ParseCloseReason(message kiface.IMessage) (*CloseReason, bool) {
+	if message.ID() != MsgIDClose || len(message.Payload()) < errorCodeByteSize {
+		return nil, false
+	}
+	payload := message.Payload()
+	return &CloseReason{
+		Code:   binary.BigEndian.Uint32(payload),
+		Reason: string(payload[errorCodeByteSize:]),
+	}, true
+}
+
+// handleHeartbeat 处理心跳消息: 收到 Ping 时响应携带相同内容与序列号的 Pong，Pong 直接忽略
+// 心跳消息不会分发给处理器，返回true表示消息已被处理
+func handleHeartbeat(session kiface.ISession, message kiface.IMessage) bool {
+	switch message.ID() {
+	case MsgIDPing:
+		pong := NewMessage(MsgIDPong, message.Payload())
+		pong.PutSeq(message.Seq())
+		_ = session.Send(pong)
+		return true
+	case MsgIDPong:
+		return true
+	}
+	return false
+}
+
+// isHeartbeat 是否为心跳消息，心跳消息不计入会话的空闲检测
+func isHeartbeat(message kiface.IMessage) bool {
+	return message.ID() == MsgIDPing || message.ID() == MsgIDPong
+}
+
+// startKeepalive 开始会话的活跃检测，检测任务注册在服务端共享的时间轮上，不占用单独的协程
+// 开启空闲超时时，超过 idleTimeout 未收发任何业务消息则关闭会话；
+// 开启心跳时，每个心跳间隔内未收到任何消息则发送一次 Ping，连续 maxMissedBeats 次未收到消息则关闭会话.
+// 关闭会话前向客户端发送 MsgIDClose 消息说明关闭原因.
+func (ns *NormalSession) startKeepalive() {
+	if ns.server.wheel == nil {
+		return
+	}
+	now := time.Now()
+	ns.lastBeat = now
+	ns.nextBeat = now.Add(ns.server.heartbeatInterval)
+	ns.scheduleKeepalive(now)
+}
+
+// scheduleKeepalive 在时间轮上注册下一次检测，检测时间取空闲到期时间与下一次心跳时间中较早的一个
+func (ns *NormalSession) scheduleKeepalive(now time.Time) {
+	delay := time.Duration(math.MaxInt64)
+	if ns.isIdleTimeout {
+		delay = time.Unix(0, ns.lastActive.Load()).Add(ns.idleTimeout).Sub(now)
+	}
+	if ns.server.heartbeatInterval > 0 {
+		if d := ns.nextBeat.Sub(now); d < delay {
+			delay = d
+		}
+	}
+	ns.keepaliveTimer.Store(ns.server.wheel.AfterFunc(delay, ns.checkKeepalive))
+}
+
+// checkKeepalive 时间轮到期时执行的检测任务
+func (ns *NormalSession) checkKeepalive() {
+	if ns.IsClose() {
+		return
+	}
+	now := time.Now()
+	if ns.isIdleTimeout && now.Sub(time.Unix(0, ns.lastActive.Load())) >= ns.idleTimeout {
+		// 会话连接超时退出，写入关闭原因可能阻塞，不占用时间轮的批量任务协程
+		go ns.closeWithReason(CloseIdleTimeout, "idle timeout")
+		return
+	}
+	if ns.server.heartbeatInterval > 0 && !now.Before(ns.nextBeat) {
+		if ns.lastRead.Load() > ns.lastBeat.UnixNano() {
+			// 上一个心跳间隔内收到过消息，保持活跃
+			ns.missedBeats = 0
+		} else if ns.missedBeats >= ns.server.maxMissedBeats {
+			go ns.closeWithReason(CloseHeartbeatTimeout, "heartbeat timeout")
+			return
+		} else {
+			ns.missedBeats++
+			_ = ns.TrySend(NewMessage(MsgIDPing, nil))
+		}
+		ns.lastBeat = now
+		ns.nextBeat = now.Add(ns.server.heartbeatInterval)
+	}
+	ns.scheduleKeepalive(now)
+}
+
+// closeWithReason 通知客户端关闭原因后关闭会话，关闭原因经由写协程写出
+func (ns *NormalSession) closeWithReason(code uint32, reason string) {
+	fmt.Printf("[%s] Session ID: %d %s \n", ns.GetRemoteAddr(), ns.ID, reason)
+	ns.closeAfterFlush(NewCloseMessage(code, reason))
+}
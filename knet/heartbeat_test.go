@@ -0,0 +1,136 @@
+// @Title heartbeat_test.go
+// @Description 心跳的 Ping/Pong、心跳超时与空闲超时关闭会话的测试
+// @Author Zero - 2023/10/22 19:15:42
+
+package knet
+
+import (
+	"bufio"
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// keepaliveClient 读取服务端消息的测试客户端
+type keepaliveClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	packer kiface.IPacker
+}
+
+func dialKeepalive(t *testing.T, addr net.Addr) *keepaliveClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	return &keepaliveClient{conn: conn, reader: bufio.NewReader(conn), packer: NewNormalPacker()}
+}
+
+func (c *keepaliveClient) send(t *testing.T, message kiface.IMessage) {
+	t.Helper()
+	pack, err := c.packer.Pack(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.conn.Write(pack); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *keepaliveClient) recv(t *testing.T) kiface.IMessage {
+	t.Helper()
+	message, err := c.packer.UnPack(c.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// expectClose 读取关闭原因消息，随后连接被关闭
+func (c *keepaliveClient) expectClose(t *testing.T, message kiface.IMessage, code uint32) {
+	t.Helper()
+	reason, ok := ParseCloseReason(message)
+	if !ok {
+		t.Fatalf("got message ID %d, want MsgIDClose", message.ID())
+	}
+	if reason.Code != code {
+		t.Fatalf("close code %d (%s), want %d", reason.Code, reason.Reason, code)
+	}
+	if _, err := c.packer.UnPack(c.reader); !errors.Is(err, io.EOF) {
+		t.Fatalf("read after close got %v, want io.EOF", err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	t.Run("pong", func(t *testing.T) {
+		_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}))
+		client := dialKeepalive(t, addr)
+		ping := NewMessage(MsgIDPing, []byte("beat"))
+		ping.PutSeq(9)
+		client.send(t, ping)
+		pong := client.recv(t)
+		if pong.ID() != MsgIDPong || pong.Seq() != 9 || string(pong.Payload()) != "beat" {
+			t.Fatalf("got id %d seq %d payload %q", pong.ID(), pong.Seq(), pong.Payload())
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithHeartbeat(interval, 2))
+		client := dialKeepalive(t, addr)
+		// 不响应心跳: 连续两次 Ping 之后关闭会话
+		for i := 0; i < 2; i++ {
+			if message := client.recv(t); message.ID() != MsgIDPing {
+				t.Fatalf("message %d: got ID %d, want MsgIDPing", i, message.ID())
+			}
+		}
+		client.expectClose(t, client.recv(t), CloseHeartbeatTimeout)
+	})
+
+	t.Run("zero missed beats pings first", func(t *testing.T) {
+		_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithHeartbeat(interval, 0))
+		client := dialKeepalive(t, addr)
+		if message := client.recv(t); message.ID() != MsgIDPing {
+			t.Fatalf("got ID %d, want MsgIDPing before close", message.ID())
+		}
+		client.expectClose(t, client.recv(t), CloseHeartbeatTimeout)
+	})
+
+	t.Run("answered", func(t *testing.T) {
+		server, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithHeartbeat(interval, 1))
+		client := dialKeepalive(t, addr)
+		// 响应每个 Ping，会话在多个心跳间隔后仍然存活
+		deadline := time.Now().Add(6 * interval)
+		for pings := 0; time.Now().Before(deadline); pings++ {
+			ping := client.recv(t)
+			if ping.ID() != MsgIDPing {
+				t.Fatalf("got ID %d, want MsgIDPing", ping.ID())
+			}
+			client.send(t, NewMessage(MsgIDPong, ping.Payload()))
+		}
+		if count := server.GetSessionManager().Count(); count != 1 {
+			t.Fatalf("sessions = %d, answered heartbeats closed the session", count)
+		}
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}), WithIdleTimeout(100*time.Millisecond))
+	client := dialKeepalive(t, addr)
+	client.send(t, NewMessage(1, []byte("hello")))
+	if reply := client.recv(t); string(reply.Payload()) != "echo:hello" {
+		t.Fatalf("got %q", reply.Payload())
+	}
+	start := time.Now()
+	client.expectClose(t, client.recv(t), CloseIdleTimeout)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("closed after %s, before the idle timeout", elapsed)
+	}
+}
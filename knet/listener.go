@@ -0,0 +1,138 @@
+// @Title listener.go
+// @Description 多监听器支持，一个服务端同时通过多个端点接收连接，所有会话共用同一个会话空间
+// @Author Zero - 2023/10/16 10:12:44
+
+package knet
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+)
+
+// 未配置监听器列表时，默认监听器的名称
+const defaultListenerName = "default"
+
+// ListenerConfig 监听器配置
+type ListenerConfig struct {
+	// 监听器名称，会话会记录其连接所属的监听器名称，可用于鉴权
+	Name string
+	// 网络类型: "tcp" | "unix"，默认为 "tcp"
+	Network string
+	// 监听地址，network 为 "unix" 时为套接字文件路径
+	Address string
+	// Unix 域套接字文件的权限，0 表示不修改
+	SocketMode os.FileMode
+	// TLS配置，为nil时使用明文传输
+	TLSConfig *tls.Config
+	// 是否以 WebSocket 协议接收连接
+	WebSocket bool
+	// 允许 WebSocket 升级的请求路径，为空时不限制
+	WebSocketPath string
+}
+
+// build 根据配置文件构建监听器配置
+func (c *listenerConfig) build() (ListenerConfig, error) {
+	config := ListenerConfig{
+		Name:    c.Name,
+		Network: c.Network,
+		Address: c.Address,
+	}
+	if len(config.Network) == 0 {
+		config.Network = defaultNetwork
+	}
+	if len(config.Name) == 0 {
+		config.Name = config.Address
+	}
+	mode, err := parseSocketMode(c.SocketMode)
+	if err != nil {
+		return config, err
+	}
+	config.SocketMode = mode
+	if c.TLS != nil {
+		if config.TLSConfig, err = c.TLS.build(); err != nil {
+			return config, err
+		}
+	}
+	if c.WebSocket != nil {
+		config.WebSocket = true
+		config.WebSocketPath = c.WebSocket.Path
+	}
+	return config, nil
+}
+
+// listen 根据配置创建监听器: 监听TCP端口或 Unix 域套接字，再按需包装 TLS 以及 WebSocket
+func (c ListenerConfig) listen() (net.Listener, error) {
+	var listener net.Listener
+	if c.Network == "unix" {
+		var err error
+		if listener, err = listenUnix(c.Address, c.SocketMode); err != nil {
+			return nil, err
+		}
+	} else {
+		// 获取一个TCP的Addr
+		tcpAddr, err := net.ResolveTCPAddr(c.Network, c.Address)
+		if err != nil {
+			return nil, err
+		}
+		// 监听指定的Addr，获取监听器
+		if listener, err = net.ListenTCP(c.Network, tcpAddr); err != nil {
+			return nil, err
+		}
+	}
+	// 开启TLS
+	if c.TLSConfig != nil {
+		listener = tls.NewListener(listener, c.TLSConfig)
+	}
+	// 开启WebSocket
+	if c.WebSocket {
+		listener = newWebSocketListener(listener, c.WebSocketPath)
+	}
+	return listener, nil
+}
+
+// namedListener 带有名称的监听器，接收的连接会记录监听器的名称
+type namedListener struct {
+	net.Listener
+	// 监听器名称
+	name string
+}
+
+// Accept 接收连接，并且记录连接所属的监听器名称
+func (l *namedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &listenerConn{Conn: conn, listener: l.name}, nil
+}
+
+// listenerConn 记录了所属监听器名称的连接
+type listenerConn struct {
+	net.Conn
+	// 连接所属的监听器名称
+	listener string
+}
+
+// NetConn 获取底层连接
+func (c *listenerConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// HandshakeContext 完成底层连接的握手，底层连接无需握手时直接返回
+func (c *listenerConn) HandshakeContext(ctx context.Context) error {
+	if h, ok := c.Conn.(handshaker); ok {
+		return h.HandshakeContext(ctx)
+	}
+	return nil
+}
+
+// ListenerName 获取连接所属的监听器名称，非服务端接收的连接返回空字符串
+// 可在 IHandler.OnConnectHandler 中根据连接到达的端点进行鉴权，如仅允许内部端口访问管理接口
+func ListenerName(conn net.Conn) string {
+	if c, ok := conn.(*listenerConn); ok {
+		return c.listener
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+// @Title listener_test.go
+// @Description 多监听器共用会话空间以及连接所属监听器名称的测试
+// @Author Zero - 2023/10/22 20:49:36
+
+package knet
+
+import (
+	"context"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listenerNameHandler 以会话所属的监听器名称响应请求，并记录 OnConnectHandler 中连接的监听器名称
+type listenerNameHandler struct {
+	kiface.SuperHandler
+	lock      sync.Mutex
+	connected []string
+}
+
+func (h *listenerNameHandler) OnConnectHandler(conn net.Conn) context.Context {
+	h.lock.Lock()
+	h.connected = append(h.connected, ListenerName(conn))
+	h.lock.Unlock()
+	return context.Background()
+}
+
+func (h *listenerNameHandler) OnHandler(ctx kiface.IHandlerContext) error {
+	return ctx.Reply([]byte(ctx.GetSession().(*NormalSession).GetListenerName()))
+}
+
+func TestMultipleListeners(t *testing.T) {
+	handler := &listenerNameHandler{}
+	path := filepath.Join(t.TempDir(), "kinx.sock")
+	server, _ := startTestServer(t, ListenerConfig{Name: "public"},
+		WithHandler(handler),
+		WithListener(ListenerConfig{Name: "internal", Address: "127.0.0.1:0"}),
+		WithListener(ListenerConfig{Name: "local", Network: "unix", Address: path}),
+	)
+	if len(server.listeners) != 3 {
+		t.Fatalf("%d listeners, want 3", len(server.listeners))
+	}
+	names := []string{"public", "internal", "local"}
+	for i, listener := range server.listeners {
+		addr := listener.Addr()
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		reply, err := testCall(conn, NewMessage(1, nil))
+		if err != nil {
+			t.Fatalf("listener %s: %v", names[i], err)
+		}
+		if string(reply.Payload()) != names[i] {
+			t.Fatalf("session listener %q, want %q", reply.Payload(), names[i])
+		}
+	}
+	handler.lock.Lock()
+	connected := append([]string{}, handler.connected...)
+	handler.lock.Unlock()
+	if len(connected) != 3 || connected[0] != "public" || connected[1] != "internal" || connected[2] != "local" {
+		t.Fatalf("OnConnectHandler listener names %v", connected)
+	}
+	// 所有监听器接收的会话共用同一个会话空间
+	if count := server.GetSessionManager().Count(); count != 3 {
+		t.Fatalf("%d sessions, want 3", count)
+	}
+
+	// 关闭服务端时关闭所有监听器
+	addrs := make([]net.Addr, 0, len(server.listeners))
+	for _, listener := range server.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, addr := range addrs {
+		if conn, err := net.Dial(addr.Network(), addr.String()); err == nil {
+			_ = conn.Close()
+			t.Fatalf("listener %s still accepting after shutdown", names[i])
+		}
+	}
+}
+
+func TestListenerNameOutsideServer(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	if name := ListenerName(conn); name != "" {
+		t.Fatalf("got %q for a connection not accepted by a server", name)
+	}
+}
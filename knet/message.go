@@ -4,17 +4,66 @@
 
 package knet
 
-import "github.com/zlx2019/kinx/kiface"
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"math"
+)
 
-// Message 消息数据包结构
-// 消息序列化结构-> [Len|ID|Payload]
+// 系统保留的消息ID，由 uint64 的最大值向下分配，业务消息请勿使用
+const (
+	// MsgIDUnknownRoute 未匹配到路由时的响应消息ID
+	MsgIDUnknownRoute uint64 = math.MaxUint64 - iota
+	// MsgIDGoodbye 服务端关闭时通知客户端的告别消息ID
+	MsgIDGoodbye
+	// MsgIDServerBusy 超过最大连接数时通知客户端的拒绝消息ID
+	MsgIDServerBusy
+	// MsgIDFrameTooLarge 消息内容长度超过限制时通知客户端的错误消息ID
+	MsgIDFrameTooLarge
+	// MsgIDAuth 客户端发送认证凭证的消息ID
+	MsgIDAuth
+	// MsgIDAuthChallenge 服务端发送认证挑战的消息ID
+	MsgIDAuthChallenge
+	// MsgIDAuthOK 认证成功的消息ID
+	MsgIDAuthOK
+	// MsgIDAuthFailed 认证失败的消息ID，消息内容为失败原因
+	MsgIDAuthFailed
+	// MsgIDError 错误响应的消息ID，消息内容为 [Code|Message]，见 NewErrorMessage
+	MsgIDError
+	// MsgIDPing 心跳请求的消息ID，服务端与客户端均可发送，收到后需响应 MsgIDPong
+	MsgIDPing
+	// MsgIDPong 心跳响应的消息ID，消息内容与序列号与 Ping 相同
+	MsgIDPong
+	// MsgIDClose 服务端关闭会话前通知客户端关闭原因的消息ID，消息内容为 [Code|Reason]，见 NewCloseMessage
+	MsgIDClose
+)
+
+// 系统保留的消息标志位，占用标志位的低 8 位，NormalPacker 与 ExtPacker 均可携带
+const (
+	// FlagGzip 消息内容经过 gzip 压缩
+	FlagGzip uint16 = 1 << iota
+	// FlagZlib 消息内容经过 zlib 压缩
+	FlagZlib
+	// FlagDeflate 消息内容经过 deflate 压缩
+	FlagDeflate
+)
+
+// Message 消息数据包结构，实现了 kiface.IMessage 以及 kiface.IMessageHeader
+// 消息序列化结构-> [Len|ID|Payload]，携带序列号时为 [Len|ID|Seq|Payload]
 type Message struct {
 	// 数据内容长度长度,
 	len uint64
 	// 消息ID
 	id uint64
+	// 消息序列号，0 表示不携带
+	seq uint64
+	// 消息标志位
+	flags uint16
+	// 元数据
+	metadata map[string]string
 	// 消息数据内容
 	payload []byte
+	// 消息内容所在的缓冲池缓冲区，由消息处理器解包时设置，Release 时归还
+	buffer *[]byte
 }
 
 // NewMessage 构建一个消息
@@ -26,6 +75,17 @@ func NewMessage(id uint64, payload []byte) kiface.IMessage {
 	}
 }
 
+// newPooledMessage 构建一个消息内容来自缓冲池的消息
+// buffer 为从缓冲池获取的缓冲区，payload 为 buffer 中消息内容所在的部分
+func newPooledMessage(id uint64, payload []byte, buffer *[]byte) *Message {
+	return &Message{
+		len:     uint64(len(payload)),
+		id:      id,
+		payload: payload,
+		buffer:  buffer,
+	}
+}
+
 func (m *Message) Len() uint64 {
 	return m.len
 }
@@ -38,6 +98,10 @@ func (m *Message) Payload() []byte {
 	return m.payload
 }
 
+func (m *Message) Seq() uint64 {
+	return m.seq
+}
+
 func (m *Message) PutID(id uint64) {
 	m.id = id
 }
@@ -49,3 +113,40 @@ func (m *Message) PutLen(len uint64) {
 func (m *Message) PutPayload(payload []byte) {
 	m.payload = payload
 }
+
+func (m *Message) PutSeq(seq uint64) {
+	m.seq = seq
+}
+
+func (m *Message) Flags() uint16 {
+	return m.flags
+}
+
+func (m *Message) PutFlags(flags uint16) {
+	m.flags = flags
+}
+
+func (m *Message) Meta(key string) (string, bool) {
+	value, ok := m.metadata[key]
+	return value, ok
+}
+
+func (m *Message) PutMeta(key, value string) {
+	if m.metadata == nil {
+		m.metadata = make(map[string]string)
+	}
+	m.metadata[key] = value
+}
+
+func (m *Message) Metadata() map[string]string {
+	return m.metadata
+}
+
+func (m *Message) Release() {
+	if m.buffer == nil {
+		return
+	}
+	putBuffer(m.buffer)
+	m.buffer = nil
+	m.payload = nil
+}
@@ -0,0 +1,49 @@
+// @Title middleware.go
+// @Description 中间件处理链以及内置中间件
+// @Author Zero - 2023/9/26 14:32:08
+
+package knet
+
+import (
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// wrapMiddlewares 将中间件转换为处理链中的处理函数，next 即为上下文的 Next
+func wrapMiddlewares(middlewares []kiface.Middleware) []kiface.HandlerFunc {
+	handlers := make([]kiface.HandlerFunc, 0, len(middlewares)+1)
+	for _, middleware := range middlewares {
+		mw := middleware
+		handlers = append(handlers, func(ctx kiface.IHandlerContext) error {
+			return mw(ctx, ctx.Next)
+		})
+	}
+	return handlers
+}
+
+// Recovery 异常恢复中间件，将处理链中的 panic 转换为 error 返回，防止读协程崩溃
+func Recovery() kiface.Middleware {
+	return func(ctx kiface.IHandlerContext, next func() error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] handler panic: %v \n%s", ctx.GetSession().GetRemoteAddr(), r, debug.Stack())
+				err = fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		return next()
+	}
+}
+
+// Logger 日志中间件，打印每条消息的处理耗时
+func Logger() kiface.Middleware {
+	return func(ctx kiface.IHandlerContext, next func() error) error {
+		start := time.Now()
+		err := next()
+		log.Printf("[%s] message ID: %d handled in %s, err: %v \n",
+			ctx.GetSession().GetRemoteAddr(), ctx.GetMessage().ID(), time.Since(start), err)
+		return err
+	}
+}
@@ -0,0 +1,156 @@
+// @Title middleware_test.go
+// @Description 全局与路由中间件的执行顺序、Abort 中止处理链以及 Recovery 异常恢复的测试
+// @Author Zero - 2023/10/22 20:23:09
+
+package knet
+
+import (
+	"errors"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// traceRecorder 记录中间件与处理函数的执行顺序
+type traceRecorder struct {
+	lock  sync.Mutex
+	trace []string
+}
+
+func (r *traceRecorder) add(step string) {
+	r.lock.Lock()
+	r.trace = append(r.trace, step)
+	r.lock.Unlock()
+}
+
+// take 取出并清空已记录的执行顺序
+func (r *traceRecorder) take() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	trace := strings.Join(r.trace, ",")
+	r.trace = nil
+	return trace
+}
+
+// middleware 记录调用 next 前后的中间件
+func (r *traceRecorder) middleware(name string) kiface.Middleware {
+	return func(ctx kiface.IHandlerContext, next func() error) error {
+		r.add(name + ">")
+		err := next()
+		r.add("<" + name)
+		return err
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	recorder := &traceRecorder{}
+	router := NewRouter()
+	router.AddRoute(1, func(ctx kiface.IHandlerContext) error {
+		recorder.add("handler")
+		return ctx.Reply(nil)
+	}, recorder.middleware("route1"), recorder.middleware("route2"))
+	// 未调用 next 的中间件返回后继续执行后续的处理函数
+	router.AddRoute(2, func(ctx kiface.IHandlerContext) error {
+		recorder.add("handler")
+		return ctx.Reply(nil)
+	}, func(ctx kiface.IHandlerContext, next func() error) error {
+		recorder.add("passive")
+		return nil
+	})
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router),
+		WithMiddleware(recorder.middleware("global1"), recorder.middleware("global2")))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cases := []struct {
+		id   uint64
+		want string
+	}{
+		{1, "global1>,global2>,route1>,route2>,handler,<route2,<route1,<global2,<global1"},
+		{2, "global1>,global2>,passive,handler,<global2,<global1"},
+	}
+	for _, c := range cases {
+		if _, err = testCall(conn, NewMessage(c.id, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if trace := recorder.take(); trace != c.want {
+			t.Fatalf("id %d: trace %s, want %s", c.id, trace, c.want)
+		}
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	recorder := &traceRecorder{}
+	// 未通过校验的消息以错误消息响应并中止处理链
+	guard := func(ctx kiface.IHandlerContext, next func() error) error {
+		if len(ctx.GetMessage().Payload()) == 0 {
+			ctx.Abort()
+			return ctx.ReplyError(ErrCodeBadRequest, "empty payload")
+		}
+		return next()
+	}
+	router := NewRouter()
+	router.AddRoute(1, func(ctx kiface.IHandlerContext) error {
+		recorder.add("handler")
+		return ctx.Reply(ctx.GetMessage().Payload())
+	}, guard, recorder.middleware("after"))
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reply, err := testCall(conn, NewMessage(1, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := ParseErrorReply(reply); !ok || e.Code != ErrCodeBadRequest {
+		t.Fatalf("got id %d payload %q, want bad request", reply.ID(), reply.Payload())
+	}
+	if trace := recorder.take(); trace != "" {
+		t.Fatalf("aborted chain ran %s", trace)
+	}
+	// 中止只作用于本条消息
+	if reply, err = testCall(conn, NewMessage(1, []byte("ok"))); err != nil || string(reply.Payload()) != "ok" {
+		t.Fatalf("after abort got %v, %v", reply, err)
+	}
+	if trace := recorder.take(); trace != "after>,handler,<after" {
+		t.Fatalf("trace %s", trace)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute(1, func(ctx kiface.IHandlerContext) error {
+		panic("boom")
+	})
+	router.AddRoute(2, replyWith("alive"))
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router), WithMiddleware(Recovery()))
+
+	// panic 转换为错误，关闭该会话
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = testCall(conn, NewMessage(1, nil)); !errors.Is(err, io.EOF) {
+		t.Fatalf("got %v, want io.EOF after panic", err)
+	}
+	// 服务端不受影响，继续处理其他连接
+	other, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	reply, err := testCall(other, NewMessage(2, nil))
+	if err != nil || string(reply.Payload()) != "alive" {
+		t.Fatalf("after panic got %v, %v", reply, err)
+	}
+}
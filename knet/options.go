@@ -5,9 +5,12 @@
 package knet
 
 import (
+	"crypto/tls"
 	"github.com/panjf2000/ants/v2"
 	"github.com/zlx2019/kinx/kiface"
 	"log"
+	"net"
+	"os"
 	"time"
 )
 
@@ -16,6 +19,16 @@ func (n *NormalServer) onHandler(handler kiface.IHandler) {
 	n.handler = handler
 }
 
+// onRouter 注册服务的消息路由器
+func (n *NormalServer) onRouter(router kiface.IRouter) {
+	n.router = router
+}
+
+// onMiddleware 注册服务的全局中间件
+func (n *NormalServer) onMiddleware(middlewares ...kiface.Middleware) {
+	n.middlewares = append(n.middlewares, wrapMiddlewares(middlewares)...)
+}
+
 // onOptions 注册服务的配置选项
 func (n *NormalServer) onOptions(options ...NormalServerOption) {
 	for _, option := range options {
@@ -39,14 +52,195 @@ func WithHandler(handler kiface.IHandler) NormalServerOption {
 	}
 }
 
-// WithIdleTimeout 设置连接空闲超时时间
+// WithRouter 设置消息路由器，设置后会话将根据消息ID分发至路由处理函数，不再回调 IHandler.OnHandler
+func WithRouter(router kiface.IRouter) NormalServerOption {
+	return func(s *NormalServer) {
+		s.onRouter(router)
+	}
+}
+
+// WithMiddleware 注册全局中间件，作用于所有消息，在路由中间件之前按注册顺序执行
+// 路由独有的中间件通过 IRouter.AddRoute 注册
+func WithMiddleware(middlewares ...kiface.Middleware) NormalServerOption {
+	return func(s *NormalServer) {
+		s.onMiddleware(middlewares...)
+	}
+}
+
+// WithIdleTimeout 设置连接空闲超时时间，超过该时间未收发任何业务消息(心跳消息除外)则关闭会话
+// 所有会话的超时检测共用一个时间轮，不为每个会话单独创建协程与定时器
 func WithIdleTimeout(timeout time.Duration) NormalServerOption {
 	return func(s *NormalServer) {
 		s.setIdleTimeout(timeout)
 	}
 }
 
-// WithPool 初始化协程池，指定协程池的协程容量
+// WithHeartbeat 开启心跳，每个心跳间隔内未收到客户端的任何消息则发送一次 MsgIDPing，
+// 连续 maxMissed 次仍未收到消息时，发送 MsgIDClose 消息后关闭会话.
+// interval <= 0 时不开启心跳；maxMissed < 1 时按 1 处理，保证关闭前至少发送过一次 Ping
+func WithHeartbeat(interval time.Duration, maxMissed int) NormalServerOption {
+	return func(s *NormalServer) {
+		if interval < 0 {
+			interval = 0
+		}
+		if maxMissed < 1 {
+			maxMissed = 1
+		}
+		s.heartbeatInterval = interval
+		s.maxMissedBeats = maxMissed
+	}
+}
+
+// WithWriteQueue 设置会话发送队列的容量以及队列已满时的处理策略，默认容量为 16，策略为 OverflowBlock
+// size <= 0 时保持默认容量
+func WithWriteQueue(size int, policy OverflowPolicy) NormalServerOption {
+	return func(s *NormalServer) {
+		if size > 0 {
+			s.writeQueueSize = size
+		}
+		s.overflowPolicy = policy
+	}
+}
+
+// WithSendTimeout 设置 OverflowBlockTimeout 策略的发送超时时间，默认为 5s，timeout <= 0 时使用默认值
+// 队列已满时 Send 最多阻塞 timeout 后返回 ErrSendTimeout；策略需通过 WithWriteQueue 设置，其他策略忽略该配置
+func WithSendTimeout(timeout time.Duration) NormalServerOption {
+	return func(s *NormalServer) {
+		if timeout <= 0 {
+			timeout = defaultSendTimeout
+		}
+		s.sendTimeout = timeout
+	}
+}
+
+// WithWriteBatch 设置写协程的批量写入策略，默认单次最多合并 64 个消息且不等待
+// maxBatch 为单次写入的最大消息数量，1 表示每个消息单独写入，<= 0 时保持默认值；
+// maxLatency 为发送队列为空后等待更多消息的最长时间，以少量延迟换取更少的系统调用，0 表示不等待
+func WithWriteBatch(maxBatch int, maxLatency time.Duration) NormalServerOption {
+	return func(s *NormalServer) {
+		if maxBatch > 0 {
+			s.writeBatchSize = maxBatch
+		}
+		s.writeBatchLatency = maxLatency
+	}
+}
+
+// PackerFactory 消息处理器工厂，在连接建立后、会话创建前由服务端调用，根据连接(如 ListenerName、TLS 连接状态)为其选择消息处理器
+// 服务端不会与客户端握手协商，客户端需自行使用与之匹配的消息处理器；返回错误时连接将被关闭
+type PackerFactory func(conn net.Conn) (kiface.IPacker, error)
+
+// WithPacker 设置所有会话共用的消息处理器，默认为 NormalPacker
+func WithPacker(packer kiface.IPacker) NormalServerOption {
+	return func(s *NormalServer) {
+		s.packer = packer
+	}
+}
+
+// WithCodec 设置消息内容编解码器，用于 IHandlerContext.Bind 以及 ReplyObject，默认为 JSONCodec
+func WithCodec(codec kiface.ICodec) NormalServerOption {
+	return func(s *NormalServer) {
+		s.codec = codec
+	}
+}
+
+// WithPackerFactory 设置消息处理器工厂，服务端为每个连接单独选择消息处理器，优先级高于 WithPacker
+func WithPackerFactory(factory PackerFactory) NormalServerOption {
+	return func(s *NormalServer) {
+		s.packerFactory = factory
+	}
+}
+
+// WithMaxPayloadSize 设置允许的最大消息内容长度，0 表示不限制，默认为 DefaultMaxPayloadSize
+// 作用于实现了 PayloadLimiter 的消息处理器，包括 WithPackerFactory 为每个连接创建的处理器
+func WithMaxPayloadSize(size uint64) NormalServerOption {
+	return func(s *NormalServer) {
+		s.isPayloadLimit = true
+		s.maxPayloadSize = size
+	}
+}
+
+// WithFrameTooLargeReply 消息内容长度超过限制时，关闭连接前向客户端发送 MsgIDFrameTooLarge 错误消息
+func WithFrameTooLargeReply() NormalServerOption {
+	return func(s *NormalServer) {
+		s.frameTooLargeReply = true
+	}
+}
+
+// WithTLSConfig 开启TLS，优先级高于配置文件中的 tls 配置
+// 设置 ClientAuth 以及 ClientCAs 即可开启双向认证
+func WithTLSConfig(config *tls.Config) NormalServerOption {
+	return func(s *NormalServer) {
+		s.tlsConfig = config
+	}
+}
+
+// WithUnixSocket 监听 Unix 域套接字，优先级高于配置文件中的 network 与 address
+// 监听前自动删除上次运行残留的套接字文件；mode 不为0时设置套接字文件的权限，如 0660
+func WithUnixSocket(path string, mode os.FileMode) NormalServerOption {
+	return func(s *NormalServer) {
+		s.protocol = "unix"
+		s.address = path
+		s.socketMode = mode
+	}
+}
+
+// WithListener 添加一个监听器，可多次设置以同时通过多个端点接收连接，如公网TLS端口、内网明文端口以及 Unix 域套接字
+// 设置后忽略配置文件中的监听配置以及 WithTLSConfig、WithUnixSocket、WithWebSocket，所有监听器共用同一个会话空间
+func WithListener(config ListenerConfig) NormalServerOption {
+	return func(s *NormalServer) {
+		if len(config.Network) == 0 {
+			config.Network = defaultNetwork
+		}
+		s.listenerConfigs = append(s.listenerConfigs, config)
+	}
+}
+
+// WithWebSocket 以 WebSocket 协议接收连接，优先级高于配置文件中的 websocket 配置
+// 每个二进制帧携带一个由消息处理器封包的消息(默认为 NormalPacker)，IHandler 无需任何修改；
+// 与 WithTLSConfig 同时使用时为 wss. path 为允许升级的请求路径，如 "/ws"，为空时不限制.
+func WithWebSocket(path string) NormalServerOption {
+	return func(s *NormalServer) {
+		s.isWebSocket = true
+		s.webSocketPath = path
+	}
+}
+
+// WithHandshakeTimeout 设置握手(TLS握手以及 WebSocket 升级握手)的超时时间，默认为10秒
+func WithHandshakeTimeout(timeout time.Duration) NormalServerOption {
+	return func(s *NormalServer) {
+		s.handshakeTimeout = timeout
+	}
+}
+
+// WithAuthenticator 开启会话认证，会话需在 timeout 内完成认证，否则连接将被关闭；timeout <= 0 时使用默认值10秒
+// 认证通过前会话不会注册到会话管理器，也不会处理任何业务消息
+func WithAuthenticator(authenticator kiface.IAuthenticator, timeout time.Duration) NormalServerOption {
+	return func(s *NormalServer) {
+		if timeout <= 0 {
+			timeout = defaultAuthTimeout
+		}
+		s.authenticator = authenticator
+		s.authTimeout = timeout
+	}
+}
+
+// WithMaxConn 设置最大连接数，超过后拒绝新的连接，0 表示不限制；优先级高于配置文件中的 max_conn
+func WithMaxConn(max int) NormalServerOption {
+	return func(s *NormalServer) {
+		s.maxConn = max
+	}
+}
+
+// WithGoodbye 设置服务端优雅关闭时发送给每个客户端的告别消息，如 NewMessage(MsgIDGoodbye, []byte("server shutdown"))
+func WithGoodbye(message kiface.IMessage) NormalServerOption {
+	return func(s *NormalServer) {
+		s.goodbye = message
+	}
+}
+
+// WithPool 初始化协程池，指定协程池的协程容量，<= 0 表示不限制
+// 每个会话长期占用两个协程，容量不足时新的连接将等待已有的会话关闭；
+// 未设置时按最大连接数计算，未限制最大连接数时不限制容量.
 func WithPool(capacity int) NormalServerOption {
 	return func(s *NormalServer) {
 		s.pool = newPool(capacity)
@@ -61,8 +255,8 @@ func newPool(capacity int) *ants.Pool {
 		// 回收空闲work的间隔。当DisablePurge为false时才生效
 		// 如5 * time.Second 表示空闲5秒后的work会被回收掉
 		opt.ExpiryDuration = time.Second * 3
-		// 在初始化池时是否进行内存预分配。
-		opt.PreAlloc = true
+		// 在初始化池时是否进行内存预分配。不限制容量的协程池无法预分配
+		opt.PreAlloc = capacity > 0
 		//指定是否使用非阻塞模式执行任务。如果设置为true，则在协程池已满的情况下，任务会立即返回一个err，而不是等待空闲协程。
 		// false表示不开启,阻塞等待可用的协程。
 		opt.Nonblocking = false
@@ -6,6 +6,7 @@ package knet
 
 import (
 	"encoding/binary"
+	"fmt"
 	"github.com/zlx2019/kinx/kiface"
 	"io"
 )
@@ -18,36 +19,108 @@ const (
 
 	// IDEndPos ID字段末尾字节位置
 	IDEndPos = 16
+	// SeqByteSize 消息序列号所占字节数
+	SeqByteSize = 8
+
+	// SeqFlag 消息长度字段的最高位，置位时表示ID之后携带 8 byte 的消息序列号
+	// 不携带序列号的消息编码结果与旧版本完全一致
+	SeqFlag uint64 = 1 << 63
+	// FlagsShift 消息长度字段中标志位的偏移，第 55~62 位存放消息标志位的低 8 位
+	// 不携带标志位的消息编码结果与旧版本完全一致
+	FlagsShift = 55
+	// lenMask 消息长度字段中内容长度所占的位
+	lenMask uint64 = 1<<FlagsShift - 1
+
+	// DefaultMaxPayloadSize 默认允许的最大消息内容长度 16MB
+	DefaultMaxPayloadSize uint64 = 16 << 20
 )
 
+// PayloadLimiter 支持限制消息内容长度的消息处理器
+// 解包时消息内容长度超过限制将返回 ErrFrameTooLarge，防止恶意的长度字段导致服务端分配大量内存
+type PayloadLimiter interface {
+	// SetMaxPayloadSize 设置允许的最大消息内容长度，0 表示不限制
+	SetMaxPayloadSize(size uint64)
+}
+
+// FlagsCarrier 能够在数据包中携带消息标志位的消息处理器
+// 不支持标志位的消息处理器(如 VarintPacker)会丢弃消息的标志位，不能被 CompressPacker 包装
+type FlagsCarrier interface {
+	// FlagsMask 获取数据包中可携带的消息标志位
+	FlagsMask() uint16
+}
+
+// checkPayloadSize 校验消息内容长度
+func checkPayloadSize(lens, max uint64) error {
+	if max > 0 && lens > max {
+		return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, lens, max)
+	}
+	return nil
+}
+
 // NormalPacker 消息数据包处理器: 根据固定的数据头长度进行解析,以 uint64(8byte)为准;
+// 数据包格式: [Len|ID|Payload]，若 Len 的 SeqFlag 位被置位则为 [Len|ID|Seq|Payload];
+// 仅支持消息标志位的低 8 位(见 FlagsShift)，不支持元数据，需要完整的扩展头部时使用 ExtPacker.
 type NormalPacker struct {
 	byteOrder binary.ByteOrder
+	// 允许的最大消息内容长度，0 表示不限制
+	maxPayloadSize uint64
 }
 
 // NewNormalPacker 构造函数
 func NewNormalPacker() kiface.IPacker {
-	return &NormalPacker{byteOrder: binary.BigEndian}
+	return &NormalPacker{byteOrder: binary.BigEndian, maxPayloadSize: DefaultMaxPayloadSize}
+}
+
+// SetMaxPayloadSize 设置允许的最大消息内容长度，0 表示不限制
+func (packer *NormalPacker) SetMaxPayloadSize(size uint64) {
+	packer.maxPayloadSize = size
+}
+
+// FlagsMask 仅可携带消息标志位的低 8 位
+func (packer *NormalPacker) FlagsMask() uint16 {
+	return 0xFF
+}
+
+// pooledPack 数据包缓冲区取自缓冲池
+func (packer *NormalPacker) pooledPack() bool {
+	return true
 }
 
 // Pack 消息打包
 func (packer *NormalPacker) Pack(message kiface.IMessage) ([]byte, error) {
-	// 计算数据包的总大(8 + 8 + 消息内容长度)
-	totalSize := HeaderByteSize + IDByteSize + len(message.Payload())
-	// 分配数据包缓冲区
-	packs := make([]byte, totalSize)
+	// 计算数据包的总大(8 + 8 + [8] + 消息内容长度)
+	headerSize := HeaderByteSize + IDByteSize
+	lens := message.Len()
+	if lens > lenMask {
+		return nil, ErrFieldOverflow
+	}
+	if message.Seq() != 0 {
+		headerSize += SeqByteSize
+		lens |= SeqFlag
+	}
+	if header, ok := message.(kiface.IMessageHeader); ok {
+		lens |= uint64(header.Flags()&0xFF) << FlagsShift
+	}
+	// 从缓冲池获取数据包缓冲区
+	packs := GetBuffer(headerSize + len(message.Payload()))
 	// 写入消息内容长度
-	packer.byteOrder.PutUint64(packs[:HeaderByteSize], message.Len())
+	packer.byteOrder.PutUint64(packs[:HeaderByteSize], lens)
 	// 写入消息ID
 	packer.byteOrder.PutUint64(packs[HeaderByteSize:IDEndPos], message.ID())
+	// 写入消息序列号
+	if message.Seq() != 0 {
+		packer.byteOrder.PutUint64(packs[IDEndPos:headerSize], message.Seq())
+	}
 	// 写入消息内容
-	copy(packs[IDEndPos:], message.Payload())
+	copy(packs[headerSize:], message.Payload())
 	return packs, nil
 }
 
 // UnPack 消息解包
 func (packer *NormalPacker) UnPack(reader io.Reader) (kiface.IMessage, error) {
-	buf := make([]byte, HeaderByteSize+IDByteSize)
+	header := getBuffer(HeaderByteSize + IDByteSize)
+	defer putBuffer(header)
+	buf := *header
 	// 读取消息内容长度和消息ID到缓冲区
 	// 这里会阻塞读取，直到读取到指定长度的数据或者发生错误
 	_, err := io.ReadFull(reader, buf)
@@ -57,11 +130,30 @@ func (packer *NormalPacker) UnPack(reader io.Reader) (kiface.IMessage, error) {
 	// 解析内容长度和消息ID
 	lens := packer.byteOrder.Uint64(buf[:HeaderByteSize])
 	id := packer.byteOrder.Uint64(buf[HeaderByteSize:IDEndPos])
-	// 读取消息内容
-	payloadBuf := make([]byte, lens)
-	_, err = io.ReadFull(reader, payloadBuf)
+	// 解析消息标志位
+	flags := uint16((lens >> FlagsShift) & 0xFF)
+	// 读取消息序列号
+	var seq uint64
+	if lens&SeqFlag != 0 {
+		if _, err = io.ReadFull(reader, buf[:SeqByteSize]); err != nil {
+			return nil, err
+		}
+		seq = packer.byteOrder.Uint64(buf[:SeqByteSize])
+	}
+	lens &= lenMask
+	// 在分配内存前校验消息内容长度
+	if err = checkPayloadSize(lens, packer.maxPayloadSize); err != nil {
+		return nil, err
+	}
+	// 从缓冲池获取缓冲区，读取消息内容
+	payloadBuf := getBuffer(int(lens))
+	_, err = io.ReadFull(reader, *payloadBuf)
 	if err != nil {
+		putBuffer(payloadBuf)
 		return nil, err
 	}
-	return NewMessage(id, payloadBuf), nil
+	message := newPooledMessage(id, *payloadBuf, payloadBuf)
+	message.PutSeq(seq)
+	message.PutFlags(flags)
+	return message, nil
 }
@@ -0,0 +1,54 @@
+// @Title reply.go
+// @Description 错误响应消息
+// @Author Zero - 2023/10/16 15:31:09
+
+package knet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+)
+
+// 错误响应消息中错误码的字节长度
+const errorCodeByteSize = 4
+
+// 内置的错误码，用于 Handle 注册的类型化路由
+const (
+	// ErrCodeBadRequest 请求的消息内容无法解码
+	ErrCodeBadRequest uint32 = 400
+	// ErrCodeInternal 处理函数返回了非 *ErrorReply 的错误
+	ErrCodeInternal uint32 = 500
+)
+
+// ErrorReply 错误响应，由 IHandlerContext.ReplyError 发送，客户端通过 ParseErrorReply 解析
+type ErrorReply struct {
+	// 错误码，由业务自定义
+	Code uint32
+	// 错误信息
+	Message string
+}
+
+func (e *ErrorReply) Error() string {
+	return fmt.Sprintf("knet: error reply %d: %s", e.Code, e.Message)
+}
+
+// NewErrorMessage 构建错误响应消息
+// 消息ID为 MsgIDError，消息内容为 [Code|Message]，Code 为大端序的 uint32
+func NewErrorMessage(code uint32, msg string) kiface.IMessage {
+	payload := make([]byte, errorCodeByteSize, errorCodeByteSize+len(msg))
+	binary.BigEndian.PutUint32(payload, code)
+	return NewMessage(MsgIDError, append(payload, msg...))
+}
+
+// ParseErrorReply 解析错误响应消息，非错误响应消息返回false
+func ParseErrorReply(message kiface.IMessage) (*ErrorReply, bool) {
+	if message.ID() != MsgIDError || len(message.Payload()) < errorCodeByteSize {
+		return nil, false
+	}
+	payload := message.Payload()
+	return &ErrorReply{
+		Code:    binary.BigEndian.Uint32(payload),
+		Message: string(payload[errorCodeByteSize:]),
+	}, true
+}
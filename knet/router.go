@@ -0,0 +1,114 @@
+// @Title router.go
+// @Description 基于消息ID的路由器实现
+// @Author Zero - 2023/9/25 10:20:17
+
+package knet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+)
+
+// rangeRoute 消息ID区间路由
+type rangeRoute struct {
+	min      uint64
+	max      uint64
+	handlers []kiface.HandlerFunc
+}
+
+// Router 消息路由器，根据消息ID将消息分发至对应的处理函数
+// 匹配优先级: 精确ID > ID区间(按注册顺序) > NotFound
+// 路由需要在服务启动前注册完毕，运行期间只读，因此无需加锁.
+type Router struct {
+	// 精确匹配的路由表
+	routes map[uint64][]kiface.HandlerFunc
+	// 区间匹配的路由表
+	ranges []rangeRoute
+	// 未匹配到路由时的处理函数
+	notFound []kiface.HandlerFunc
+}
+
+// NewRouter 创建路由器
+func NewRouter() kiface.IRouter {
+	return &Router{
+		routes:   make(map[uint64][]kiface.HandlerFunc),
+		notFound: []kiface.HandlerFunc{defaultNotFound},
+	}
+}
+
+// AddRoute 注册消息ID对应的处理函数
+func (r *Router) AddRoute(id uint64, handler kiface.HandlerFunc, middlewares ...kiface.Middleware) {
+	if _, ok := r.routes[id]; ok {
+		panic(fmt.Sprintf("route %d already registered", id))
+	}
+	r.routes[id] = buildChain(handler, middlewares)
+}
+
+// AddRangeRoute 注册消息ID区间 [min, max] 对应的处理函数
+func (r *Router) AddRangeRoute(min, max uint64, handler kiface.HandlerFunc, middlewares ...kiface.Middleware) {
+	if min > max {
+		panic(fmt.Sprintf("invalid route range [%d, %d]", min, max))
+	}
+	r.ranges = append(r.ranges, rangeRoute{min: min, max: max, handlers: buildChain(handler, middlewares)})
+}
+
+// NotFound 设置未匹配到路由时的处理函数
+func (r *Router) NotFound(handler kiface.HandlerFunc) {
+	if handler == nil {
+		handler = defaultNotFound
+	}
+	r.notFound = []kiface.HandlerFunc{handler}
+}
+
+// Match 根据消息ID匹配处理链
+func (r *Router) Match(id uint64) []kiface.HandlerFunc {
+	if handlers, ok := r.routes[id]; ok {
+		return handlers
+	}
+	for _, rr := range r.ranges {
+		if id >= rr.min && id <= rr.max {
+			return rr.handlers
+		}
+	}
+	return r.notFound
+}
+
+// buildChain 将路由中间件与处理函数组装为处理链
+func buildChain(handler kiface.HandlerFunc, middlewares []kiface.Middleware) []kiface.HandlerFunc {
+	return append(wrapMiddlewares(middlewares), handler)
+}
+
+// defaultNotFound 默认的未知路由处理函数，向客户端响应 MsgIDUnknownRoute 消息，消息内容为 "unknown route: <消息ID>"
+// 响应消息携带请求的序列号
+func defaultNotFound(ctx kiface.IHandlerContext) error {
+	payload := []byte(fmt.Sprintf("unknown route: %d", ctx.GetMessage().ID()))
+	message := NewMessage(MsgIDUnknownRoute, payload)
+	message.PutSeq(ctx.GetMessage().Seq())
+	return ctx.GetSession().Send(message)
+}
+
+// Handle 注册类型化的路由，请求与响应通过服务端的编解码器自动编解码
+// 请求无法解码时响应 ErrCodeBadRequest；fn 返回 *ErrorReply 时以其错误码响应，
+// 返回其他错误时响应 ErrCodeInternal；业务错误不会关闭会话.
+// fn 返回 nil 响应且无错误时不发送响应.
+func Handle[Req, Resp any](router kiface.IRouter, id uint64, fn func(ctx kiface.IHandlerContext, req *Req) (*Resp, error), middlewares ...kiface.Middleware) {
+	router.AddRoute(id, func(ctx kiface.IHandlerContext) error {
+		req := new(Req)
+		if err := ctx.Bind(req); err != nil {
+			return ctx.ReplyError(ErrCodeBadRequest, err.Error())
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			var reply *ErrorReply
+			if errors.As(err, &reply) {
+				return ctx.ReplyError(reply.Code, reply.Message)
+			}
+			return ctx.ReplyError(ErrCodeInternal, err.Error())
+		}
+		if resp == nil {
+			return nil
+		}
+		return ctx.ReplyObject(resp)
+	}, middlewares...)
+}
@@ -0,0 +1,111 @@
+// @Title router_test.go
+// @Description 路由器的精确匹配、区间匹配以及未知路由响应的测试
+// @Author Zero - 2023/10/22 20:14:47
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+)
+
+// replyWith 以固定内容响应请求的处理函数，用于区分命中的路由
+func replyWith(name string) kiface.HandlerFunc {
+	return func(ctx kiface.IHandlerContext) error {
+		return ctx.Reply([]byte(name))
+	}
+}
+
+// dialRouter 使用指定的路由器运行服务端，返回到服务端的连接
+func dialRouter(t *testing.T, router kiface.IRouter) net.Conn {
+	t.Helper()
+	_, addr := startTestServer(t, ListenerConfig{}, WithRouter(router))
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter()
+	router.AddRangeRoute(10, 19, replyWith("range 10-19"))
+	router.AddRangeRoute(15, 29, replyWith("range 15-29"))
+	router.AddRoute(15, replyWith("exact 15"))
+	conn := dialRouter(t, router)
+
+	cases := []struct {
+		id   uint64
+		want string
+	}{
+		// 精确路由优先于区间路由，与注册顺序无关
+		{15, "exact 15"},
+		// 重叠的区间按注册顺序匹配
+		{10, "range 10-19"},
+		{16, "range 10-19"},
+		{19, "range 10-19"},
+		{20, "range 15-29"},
+		{29, "range 15-29"},
+	}
+	for _, c := range cases {
+		reply, err := testCall(conn, NewMessage(c.id, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.ID() != c.id || string(reply.Payload()) != c.want {
+			t.Fatalf("id %d: got id %d payload %q, want %q", c.id, reply.ID(), reply.Payload(), c.want)
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		router := NewRouter()
+		router.AddRangeRoute(10, 19, replyWith("range"))
+		conn := dialRouter(t, router)
+		request := NewMessage(30, nil)
+		request.PutSeq(5)
+		reply, err := testCall(conn, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.ID() != MsgIDUnknownRoute || reply.Seq() != 5 || string(reply.Payload()) != "unknown route: 30" {
+			t.Fatalf("got id %d seq %d payload %q", reply.ID(), reply.Seq(), reply.Payload())
+		}
+		// 未知路由不会关闭会话
+		if reply, err = testCall(conn, NewMessage(10, nil)); err != nil || string(reply.Payload()) != "range" {
+			t.Fatalf("after unknown route got %v, %v", reply, err)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		router := NewRouter()
+		router.NotFound(replyWith("custom not found"))
+		conn := dialRouter(t, router)
+		reply, err := testCall(conn, NewMessage(30, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.ID() != 30 || string(reply.Payload()) != "custom not found" {
+			t.Fatalf("got id %d payload %q", reply.ID(), reply.Payload())
+		}
+	})
+}
+
+func TestRouterRegisterPanics(t *testing.T) {
+	expectPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s: no panic", name)
+			}
+		}()
+		fn()
+	}
+	router := NewRouter()
+	router.AddRoute(1, replyWith("first"))
+	expectPanic("duplicate route", func() { router.AddRoute(1, replyWith("second")) })
+	expectPanic("invalid range", func() { router.AddRangeRoute(20, 10, replyWith("range")) })
+}
@@ -6,14 +6,28 @@ package knet
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/panjf2000/ants/v2"
 	"github.com/zlx2019/kinx/kiface"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// 协程池为接收连接等非会话任务预留的协程数量
+	poolReserve = 16
+	// 接收连接失败后的最小重试间隔
+	minAcceptDelay = 5 * time.Millisecond
+	// 接收连接失败后的最大重试间隔
+	maxAcceptDelay = time.Second
+)
+
 // NormalServer 基础服务端,基于原生net库的同步阻塞的服务端
 type NormalServer struct {
 	// 服务名称
@@ -24,22 +38,84 @@ type NormalServer struct {
 	iP string
 	// 服务端端口
 	port int
+	// 监听地址，设置后优先于 iP 与 port；protocol 为 "unix" 时为套接字文件路径
+	address string
+	// Unix 域套接字文件的权限，0 表示不修改
+	socketMode os.FileMode
 	// 下一个建立连接的会话ID，采用自增策略
 	nextSessionID uint32
 	// 服务是否处于启动状态
-	isRunning bool
+	isRunning atomic.Bool
+	// 服务是否处于关闭中，关闭后不再接收新的连接
+	closing atomic.Bool
 	// 会话是否开启空闲超时处理
 	isIdleTimeout bool
 	// 会话空闲超时时间，连接空闲超过该时间强制关闭
 	idleTimeout time.Duration
+	// 心跳间隔，0 表示不开启心跳
+	heartbeatInterval time.Duration
+	// 连续未收到消息的最大心跳次数，超过后关闭会话
+	maxMissedBeats int
+	// 会话活跃检测共用的时间轮，开启空闲超时或心跳时创建
+	wheel *TimingWheel
 	// 服务端关闭信号
 	stopTrigger chan struct{}
+	// 服务端优雅关闭时发送给客户端的告别消息
+	goodbye kiface.IMessage
+	// 会话管理器
+	sessions kiface.ISessionManager
+	// 最大连接数，超过后拒绝新的连接，0 表示不限制
+	maxConn int
+	// 会话分组管理器
+	groups kiface.IGroupManager
+	// 正在建立会话的连接数量
+	connecting int32
+	// 消息封包与解包处理器，所有会话共用
+	packer kiface.IPacker
+	// 消息处理器工厂，为每个连接单独创建消息处理器，优先级高于 packer
+	packerFactory PackerFactory
+	// 是否设置了最大消息内容长度，未设置时使用消息处理器的默认值
+	isPayloadLimit bool
+	// 允许的最大消息内容长度，0 表示不限制
+	maxPayloadSize uint64
+	// 消息内容长度超过限制时，是否向客户端发送错误消息
+	frameTooLargeReply bool
+	// 会话发送队列的容量
+	writeQueueSize int
+	// 会话发送队列已满时的处理策略
+	overflowPolicy OverflowPolicy
+	// OverflowBlockTimeout 策略下的发送超时时间
+	sendTimeout time.Duration
+	// 写协程单次批量写入的最大消息数量，1 表示不合并
+	writeBatchSize int
+	// 写协程等待更多消息以合并写入的最长时间，0 表示不等待
+	writeBatchLatency time.Duration
+	// TLS配置，为nil时使用明文传输
+	tlsConfig *tls.Config
+	// 握手(TLS握手以及 WebSocket 升级握手)的超时时间
+	handshakeTimeout time.Duration
+	// 是否以 WebSocket 协议接收连接
+	isWebSocket bool
+	// 允许 WebSocket 升级的请求路径，为空时不限制
+	webSocketPath string
+	// 会话认证器，为nil时不进行认证
+	authenticator kiface.IAuthenticator
+	// 会话认证的超时时间
+	authTimeout time.Duration
 	// 会话处理器
 	handler kiface.IHandler
+	// 消息路由器
+	router kiface.IRouter
+	// 全局中间件
+	middlewares []kiface.HandlerFunc
+	// 消息内容编解码器，用于 IHandlerContext.Bind 以及 ReplyObject
+	codec kiface.ICodec
 	// 协程池
 	pool *ants.Pool
-	// 服务端的TCP服务监听器
-	listener net.Listener
+	// 通过 WithListener 设置的监听器配置
+	listenerConfigs []ListenerConfig
+	// 服务端的监听器
+	listeners []*namedListener
 }
 
 // NewNormalServer 创建服务端
@@ -50,38 +126,71 @@ func NewNormalServer(opts ...NormalServerOption) kiface.IServer {
 	// 加载配置文件
 	loadConfigs()
 	server := &NormalServer{
-		name:        configs.Name,
-		protocol:    "tcp",
-		iP:          configs.Host,
-		port:        configs.Port,
-		stopTrigger: make(chan struct{}),
+		name:             configs.Name,
+		protocol:         configs.Network,
+		iP:               configs.Host,
+		port:             configs.Port,
+		address:          configs.Address,
+		stopTrigger:      make(chan struct{}),
+		sessions:         NewSessionManager(),
+		groups:           NewGroupManager(),
+		packer:           NewNormalPacker(),
+		codec:            NewJSONCodec(),
+		maxConn:          configs.MaxConn,
+		writeQueueSize:   defaultWriteQueueSize,
+		sendTimeout:      defaultSendTimeout,
+		writeBatchSize:   defaultWriteBatchSize,
+		handshakeTimeout: defaultHandshakeTimeout,
 	}
 	// 注册要设置的配置
 	server.onOptions(opts...)
+	server.limitPayload(server.packer)
+	if server.isIdleTimeout || server.heartbeatInterval > 0 {
+		server.wheel = NewTimingWheel(wheelTick(server.idleTimeout, server.heartbeatInterval))
+	}
+	if server.pool == nil {
+		server.pool = newPool(server.poolCapacity())
+	}
 	return server
 }
 
+// poolCapacity 默认的协程池容量
+// 每个会话在存活期间长期占用读、写两个协程，因此容量按最大连接数计算，并为接收连接等任务预留少量协程;
+// 未限制最大连接数时协程池也不限制容量，避免连接数达到协程池容量后阻塞接收新的连接.
+func (n *NormalServer) poolCapacity() int {
+	if n.maxConn <= 0 {
+		return -1
+	}
+	return 2*n.maxConn + poolReserve
+}
+
 // Run 运行服务，并且阻塞监听连接
 func (n *NormalServer) Run() error {
 	// 创建TCP服务
 	if err := n.ready(); err != nil {
-		fmt.Println("tcp server ready failed cause: ", err.Error())
+		fmt.Printf("%s server ready failed cause: %s \n", n.protocol, err.Error())
 		return err
 	}
 	// 标记服务为运行状态
-	n.isRunning = true
-	fmt.Printf("%s running successful. address in: %s \n", n.name, n.listener.Addr().String())
+	n.isRunning.Store(true)
+	if n.wheel != nil {
+		n.wheel.Start()
+	}
+	for _, listener := range n.listeners {
+		fmt.Printf("%s running successful. [%s] address in: %s \n", n.name, listener.name, listener.Addr().String())
+	}
 
-	// 开启协程任务，开始接收客户端连接并且处理
-	_ = n.pool.Submit(n.start)
+	// 开启协程任务，每个监听器一个，开始接收客户端连接并且处理
+	for _, listener := range n.listeners {
+		listener := listener
+		_ = n.pool.Submit(func() { n.start(listener) })
+	}
 
 	//TODO 额外业务处理
 
 	// 阻塞等待服务关闭
-	select {
-	case <-n.stopTrigger:
-		fmt.Printf("%s shutodwn successful. \n", n.name)
-	}
+	<-n.stopTrigger
+	fmt.Printf("%s shutodwn successful. \n", n.name)
 	return nil
 }
 
@@ -89,99 +198,359 @@ func (n *NormalServer) Run() error {
 func (n *NormalServer) AsyncRun() error {
 	// 创建TCP服务
 	if err := n.ready(); err != nil {
-		fmt.Println("tcp server ready failed cause: ", err.Error())
+		fmt.Printf("%s server ready failed cause: %s \n", n.protocol, err.Error())
 		return err
 	}
 	// 标记服务为运行状态
-	n.isRunning = true
-	fmt.Printf("%s running successful. address in: %s \n", n.name, n.listener.Addr().String())
+	n.isRunning.Store(true)
+	if n.wheel != nil {
+		n.wheel.Start()
+	}
+	for _, listener := range n.listeners {
+		fmt.Printf("%s running successful. [%s] address in: %s \n", n.name, listener.name, listener.Addr().String())
+	}
 	return nil
 }
 
 // GetSession 阻塞等待客户端连接，并且封装为会话
+// 配置了多个监听器时，仅从第一个监听器接收连接
 func (n *NormalServer) GetSession() (kiface.ISession, error) {
-	conn, err := n.listener.Accept()
+	conn, err := n.listeners[0].Accept()
+	if err != nil {
+		return nil, err
+	}
+	session, err := n.newSession(conn)
 	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// newSession 根据连接创建会话: 选择消息处理器，回调连接建立事件，并将会话注册至会话管理器
+func (n *NormalServer) newSession(conn net.Conn) (*NormalSession, error) {
+	// TLS连接先完成握手，以便在连接建立事件中获取客户端证书
+	if err := handshake(conn, n.handshakeTimeout); err != nil {
 		return nil, err
 	}
-	var ctx context.Context
+	// 选择本次连接使用的消息处理器
+	packer := n.packer
+	if n.packerFactory != nil {
+		var err error
+		if packer, err = n.packerFactory(conn); err != nil {
+			return nil, err
+		}
+		n.limitPayload(packer)
+	}
+	id := atomic.AddUint32(&n.nextSessionID, 1) - 1
+	// 连接建立完成，回调连接建立事件处理函数，获取自定义的会话的上下文
+	ctx := context.Background()
 	if n.handler != nil {
 		ctx = n.handler.OnConnectHandler(conn)
 	}
-	session := NewNormalSession(n.nextSessionID, conn, n.handler, ctx, nil, n.isIdleTimeout, n.idleTimeout)
+	// 创建会话的上下文，用于控制会话的退出
+	sessionCtx, cancel := context.WithCancel(ctx)
+	session := NewNormalSession(n, id, conn, packer, sessionCtx, cancel)
+	// 认证通过后才注册会话
+	principal, err := n.authenticate(session)
+	if err != nil {
+		session.Stop()
+		return nil, err
+	}
+	session.principal = principal
+	n.sessions.Add(session)
+	if n.closing.Load() {
+		// 会话建立期间服务端已开始关闭
+		session.Stop()
+		return nil, ErrServerClosed
+	}
 	return session, nil
 }
 
-// 创建TCP网络服务
+// 创建网络服务，为每个监听器配置创建监听器
 func (n *NormalServer) ready() error {
-	if n.isRunning {
+	if n.isRunning.Load() {
 		panic("server already running")
 	}
-	address := fmt.Sprintf("%s:%d", n.iP, n.port)
-	// 获取一个TCP的Addr
-	tcpAddr, err := net.ResolveTCPAddr(n.protocol, address)
+	list, err := n.listenerConfigList()
 	if err != nil {
 		return err
 	}
-	// 监听指定的Addr，获取监听器，
-	n.listener, err = net.ListenTCP(n.protocol, tcpAddr)
-	if err != nil {
-		return err
+	for _, config := range list {
+		listener, err := config.listen()
+		if err != nil {
+			// 关闭已创建的监听器
+			for _, l := range n.listeners {
+				_ = l.Close()
+			}
+			n.listeners = nil
+			return err
+		}
+		n.listeners = append(n.listeners, &namedListener{Listener: listener, name: config.Name})
 	}
 	return nil
 }
 
-// 异步循环处理客户端连接
-func (n *NormalServer) start() {
+// listenerConfigList 获取监听器配置列表
+// 优先级: WithListener > 配置文件中的 listeners > 单个监听器(服务端选项以及配置文件中的 network、address、tls、websocket)
+func (n *NormalServer) listenerConfigList() ([]ListenerConfig, error) {
+	if len(n.listenerConfigs) > 0 {
+		return n.listenerConfigs, nil
+	}
+	if len(configs.Listeners) > 0 {
+		list := make([]ListenerConfig, 0, len(configs.Listeners))
+		for _, c := range configs.Listeners {
+			config, err := c.build()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, config)
+		}
+		return list, nil
+	}
+	// 未通过 WithTLSConfig 设置时，加载配置文件中的TLS配置
+	if n.tlsConfig == nil && configs.TLS != nil {
+		tlsConfig, err := configs.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		n.tlsConfig = tlsConfig
+	}
+	// 未通过 WithUnixSocket 设置时，使用配置文件中的套接字文件权限
+	if n.protocol == "unix" && n.socketMode == 0 {
+		mode, err := parseSocketMode(configs.SocketMode)
+		if err != nil {
+			return nil, err
+		}
+		n.socketMode = mode
+	}
+	// 未通过 WithWebSocket 设置时使用配置文件中的 websocket 配置
+	if !n.isWebSocket && configs.WebSocket != nil {
+		n.isWebSocket = true
+		n.webSocketPath = configs.WebSocket.Path
+	}
+	return []ListenerConfig{{
+		Name:          defaultListenerName,
+		Network:       n.protocol,
+		Address:       n.listenAddress(),
+		SocketMode:    n.socketMode,
+		TLSConfig:     n.tlsConfig,
+		WebSocket:     n.isWebSocket,
+		WebSocketPath: n.webSocketPath,
+	}}, nil
+}
+
+// listenAddress 获取监听地址，未设置 address 时由 iP 与 port 组成
+func (n *NormalServer) listenAddress() string {
+	if len(n.address) > 0 {
+		return n.address
+	}
+	return fmt.Sprintf("%s:%d", n.iP, n.port)
+}
+
+// 异步循环处理监听器接收的客户端连接
+func (n *NormalServer) start(listener net.Listener) {
+	// 接收连接失败后的重试间隔
+	var delay time.Duration
 	for {
 		// 阻塞等待客户端连接
-		conn, err := n.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if n.closing.Load() || errors.Is(err, net.ErrClosed) {
+				// 服务端关闭，监听器已关闭，退出循环
+				return
+			}
+			// 临时错误(如文件描述符耗尽)，逐步增加重试间隔，避免空转占满CPU
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			fmt.Printf("%s accept failed cause: %s, retrying in %s \n", n.name, err.Error(), delay)
+			time.Sleep(delay)
 			continue
 		}
+		delay = 0
 		// 判断当前协程池内数量是否够用
 		//if !n.checkTaskQuantity() {
 		//	_, _ = conn.Write([]byte("当前系统繁忙，请稍后再试~"))
 		//	_ = conn.Close()
 		//	continue
 		//}
-		fmt.Printf("Conn session successful. ID of: %d \n", n.nextSessionID)
-		// 连接建立完成，回调连接建立事件处理函数，获取自定义的会话的上下文
-		var ctx context.Context
-		if n.handler != nil {
-			ctx = n.handler.OnConnectHandler(conn)
+		// 超过最大连接数，拒绝连接
+		if n.maxConn > 0 && n.sessions.Count()+int(atomic.LoadInt32(&n.connecting)) >= n.maxConn {
+			// 拒绝前可能需要先完成握手，避免阻塞接收新的连接
+			go n.reject(conn)
+			continue
 		}
-		// 创建会话的上下文，用于控制会话的退出
-		sessionCtx, cancel := context.WithCancel(ctx)
-		// 根据连接，创建一个连接会话，并且启动会话
-		session := NewNormalSession(n.nextSessionID, conn, n.handler, sessionCtx, cancel, n.isIdleTimeout, n.idleTimeout)
-
-		// 启动3个协程，分别执行读、写任务以及心跳监控
-		_ = n.pool.Submit(session.Reader)
-		_ = n.pool.Submit(session.Writer)
-		if n.isIdleTimeout {
-			_ = n.pool.Submit(session.idleTimeOuter)
+		// 在协程中完成会话的建立，避免连接的握手阻塞接收新的连接
+		atomic.AddInt32(&n.connecting, 1)
+		if err = n.pool.Submit(func() { n.serve(conn) }); err != nil {
+			atomic.AddInt32(&n.connecting, -1)
+			_ = conn.Close()
 		}
-		// ID自增
-		n.nextSessionID++
+	}
+}
+
+// serve 建立连接的会话，并且启动会话的读写任务
+func (n *NormalServer) serve(conn net.Conn) {
+	// 根据连接，创建一个连接会话，并且启动会话
+	session, err := n.newSession(conn)
+	atomic.AddInt32(&n.connecting, -1)
+	if err != nil {
+		fmt.Printf("[%s] 会话建立失败: %s \n", conn.RemoteAddr(), err.Error())
+		_ = conn.Close()
+		return
+	}
+	fmt.Printf("Conn session successful. ID of: %d \n", session.ID)
 
-		fmt.Printf("[%s] 会话运行成功，当前系统任务运行数量: %d \n", session.GetRemoteAddr(), n.pool.Running())
+	// 写任务提交至协程池，读任务直接在当前协程中执行，活跃检测由时间轮负责
+	// 写任务提交失败(协程池已满或已释放)时关闭会话，避免会话没有读写任务却仍占用连接数
+	if err = n.pool.Submit(session.Writer); err != nil {
+		fmt.Printf("[%s] 会话启动失败: %s \n", session.GetRemoteAddr(), err.Error())
+		session.Stop()
+		return
 	}
+	session.startKeepalive()
+	fmt.Printf("[%s] 会话运行成功，当前系统任务运行数量: %d \n", session.GetRemoteAddr(), n.pool.Running())
+	session.Reader()
 }
 
 // 查看当前可用的空闲协程是否足够
 func (n *NormalServer) checkTaskQuantity() bool {
-	if n.isIdleTimeout {
-		return n.pool.Free() >= 3
-	}
 	return n.pool.Free() >= 2
 }
 
-// Shutdown 停止服务
-func (n *NormalServer) Shutdown() error {
-	if n.isRunning {
-		// 关闭服务端
-		n.stopTrigger <- struct{}{}
+// reject 拒绝连接，通知客户端服务繁忙后关闭连接
+func (n *NormalServer) reject(conn net.Conn) {
+	fmt.Printf("[%s] 超过最大连接数: %d，拒绝连接 \n", conn.RemoteAddr(), n.maxConn)
+	pack, err := n.packer.Pack(NewMessage(MsgIDServerBusy, []byte("server busy")))
+	if err == nil && handshake(conn, time.Second) == nil {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = conn.Write(pack)
 	}
-	return nil
+	_ = conn.Close()
+}
+
+// authenticate 在超时时间内对会话进行认证，返回认证主体；超时后关闭会话以唤醒阻塞中的读操作
+func (n *NormalServer) authenticate(session kiface.ISession) (any, error) {
+	if n.authenticator == nil {
+		return nil, nil
+	}
+	timer := time.AfterFunc(n.authTimeout, session.Stop)
+	principal, err := n.authenticator.Authenticate(session)
+	if !timer.Stop() {
+		return nil, ErrAuthTimeout
+	}
+	return principal, err
+}
+
+// dispatch 分发消息，执行 全局中间件 -> 路由处理链 的处理链
+// 设置了路由器时根据消息ID匹配路由处理链，否则以 IHandler.OnHandler 作为处理函数
+func (n *NormalServer) dispatch(session kiface.ISession, message kiface.IMessage) error {
+	handlers := make([]kiface.HandlerFunc, 0, len(n.middlewares)+2)
+	handlers = append(handlers, n.middlewares...)
+	if n.router != nil {
+		handlers = append(handlers, n.router.Match(message.ID())...)
+	} else if n.handler != nil {
+		handlers = append(handlers, n.handler.OnHandler)
+	}
+	ctx := NewHandlerContext(session, message, session.GetContext()).(*HandlerContext)
+	ctx.codec = n.codec
+	return ctx.run(handlers)
+}
+
+// limitPayload 为消息处理器设置允许的最大消息内容长度
+func (n *NormalServer) limitPayload(packer kiface.IPacker) {
+	if !n.isPayloadLimit {
+		return
+	}
+	if limiter, ok := packer.(PayloadLimiter); ok {
+		limiter.SetMaxPayloadSize(n.maxPayloadSize)
+	}
+}
+
+// drainer 支持优雅关闭的会话
+type drainer interface {
+	// shutdown 发送告别消息，处理完剩余的消息后关闭会话，ctx 到期时直接返回
+	shutdown(ctx context.Context, goodbye kiface.IMessage)
+}
+
+// drainSessions 并发优雅关闭会话管理器中的所有会话，ctx 到期后强制关闭剩余的会话
+// 不支持优雅关闭的会话发送告别消息后直接关闭
+func drainSessions(ctx context.Context, sessions kiface.ISessionManager, goodbye kiface.IMessage) error {
+	var wg sync.WaitGroup
+	sessions.Range(func(session kiface.ISession) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d, ok := session.(drainer); ok {
+				d.shutdown(ctx, goodbye)
+				return
+			}
+			if goodbye != nil {
+				_ = session.Send(goodbye)
+			}
+			session.Stop()
+		}()
+		return true
+	})
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		// 超过关闭期限，强制关闭剩余的会话
+		sessions.Range(func(session kiface.ISession) bool {
+			session.Stop()
+			return true
+		})
+		return ctx.Err()
+	}
+}
+
+// GetSessionManager 获取会话管理器，用于查找、遍历会话以及广播消息
+func (n *NormalServer) GetSessionManager() kiface.ISessionManager {
+	return n.sessions
+}
+
+// GetGroupManager 获取会话分组管理器，用于组播消息以及注册分组成员变更回调
+func (n *NormalServer) GetGroupManager() kiface.IGroupManager {
+	return n.groups
+}
+
+// Shutdown 优雅关闭服务
+// 1. 关闭监听器，不再接收新的连接;
+// 2. 向所有会话发送告别消息，等待正在处理的消息处理完毕，并将会话通道内剩余的消息写入连接;
+// 3. ctx 到期后强制关闭剩余的会话;
+// 4. 释放协程池，唤醒阻塞在 Run 中的协程.
+func (n *NormalServer) Shutdown(ctx context.Context) error {
+	if !n.isRunning.Load() || !n.closing.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+	// 停止接收新的连接
+	var err error
+	for _, listener := range n.listeners {
+		if closeErr := listener.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	// 并发优雅关闭所有会话
+	if drainErr := drainSessions(ctx, n.sessions, n.goodbye); drainErr != nil {
+		err = drainErr
+	}
+	// 停止时间轮，释放协程池
+	if n.wheel != nil {
+		n.wheel.Stop()
+	}
+	n.pool.Release()
+	n.isRunning.Store(false)
+	// 唤醒 Run
+	close(n.stopTrigger)
+	return err
 }
@@ -0,0 +1,108 @@
+// @Title server_test.go
+// @Description 测试使用的服务端启动工具以及回显处理器
+// @Author Zero - 2023/10/22 15:20:36
+
+package knet
+
+import (
+	"bufio"
+	"context"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+	"time"
+)
+
+// testEchoHandler 回显处理器，以相同的消息ID以及序列号响应 "echo:" + 请求内容
+type testEchoHandler struct {
+	kiface.SuperHandler
+}
+
+func (h *testEchoHandler) OnHandler(ctx kiface.IHandlerContext) error {
+	return ctx.Reply(append([]byte("echo:"), ctx.GetMessage().Payload()...))
+}
+
+// startTestServer 使用指定的监听器配置在后台运行服务端，返回服务端以及监听地址
+// 监听地址使用随机端口，测试结束时关闭服务端
+func startTestServer(t testing.TB, config ListenerConfig, opts ...NormalServerOption) (*NormalServer, net.Addr) {
+	t.Helper()
+	if len(config.Address) == 0 {
+		config.Address = "127.0.0.1:0"
+	}
+	opts = append([]NormalServerOption{WithListener(config)}, opts...)
+	server := NewNormalServer(opts...).(*NormalServer)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.Run()
+	}()
+	deadline := time.Now().Add(3 * time.Second)
+	for !server.isRunning.Load() {
+		select {
+		case err := <-failed:
+			t.Fatalf("server run: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return server, server.listeners[0].Addr()
+}
+
+// testCall 使用 NormalPacker 向连接发送一条消息并读取一条响应
+func testCall(conn net.Conn, message kiface.IMessage) (kiface.IMessage, error) {
+	packer := NewNormalPacker()
+	pack, err := packer.Pack(message)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Write(pack); err != nil {
+		return nil, err
+	}
+	return packer.UnPack(bufio.NewReader(conn))
+}
+
+func TestSessionWorkers(t *testing.T) {
+	server, addr := startTestServer(t, ListenerConfig{}, WithHandler(&testEchoHandler{}))
+	const clients = 3
+	for i := 0; i < clients; i++ {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		if _, err = testCall(conn, NewMessage(1, []byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 接收连接的协程，以及每个会话的读、写协程各一个
+	if running := server.pool.Running(); running != 1+2*clients {
+		t.Fatalf("pool running = %d, want %d", running, 1+2*clients)
+	}
+}
+
+func TestPoolCapacity(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []NormalServerOption
+		want int
+	}{
+		{"unlimited", nil, -1},
+		{"max conn", []NormalServerOption{WithMaxConn(10)}, 2*10 + poolReserve},
+		{"explicit", []NormalServerOption{WithMaxConn(10), WithPool(5)}, 5},
+	}
+	for _, c := range cases {
+		server := NewNormalServer(c.opts...).(*NormalServer)
+		if got := server.pool.Cap(); got != c.want {
+			t.Errorf("%s: pool capacity = %d, want %d", c.name, got, c.want)
+		}
+		server.pool.Release()
+	}
+}
@@ -5,140 +5,282 @@
 package knet
 
 import (
+	"bufio"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/zlx2019/kinx/kiface"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// closeFlushTimeout 会话主动关闭前等待写协程写出剩余消息的最长时间
+const closeFlushTimeout = time.Second
+
 // NormalSession 同步阻塞式客户端会话连接，用于管理客户端的连接，搭配NormalServer服务端使用;
 type NormalSession struct {
 	// 会话ID
 	ID uint32
 	// 客户端连接
 	Conn net.Conn
+	// 连接的读缓冲区，减少解包时的系统调用次数
+	reader *bufio.Reader
 	// 会话连接是否关闭
-	IsClosed bool
+	closed atomic.Bool
+	// 会话是否处于优雅关闭中，读协程处理完当前消息后退出
+	draining atomic.Bool
+	// 保证会话只关闭一次
+	closeOnce sync.Once
+	// 读协程退出信号
+	readerDone chan struct{}
+	// 写协程刷新剩余消息并退出的信号
+	flushing chan struct{}
+	// 保证 flushing 只关闭一次，服务端关闭与会话超时可能同时触发优雅关闭
+	flushOnce sync.Once
+	// 写协程退出信号
+	writerDone chan struct{}
 	// 会话上下文
 	context context.Context
 	// 会话上下文取消方法
 	cancel context.CancelFunc
+	// 会话所属的服务端
+	server *NormalServer
+	// 认证主体，会话认证通过后设置
+	principal any
+	// 连接所属的监听器名称
+	listener string
 
 	// 会话是否开启空闲超时处理
 	isIdleTimeout bool
 	// 会话空闲超时时间，连接空闲超过该时间强制关闭
 	idleTimeout time.Duration
+	// 最后一次收发业务消息的时间(UnixNano)，用于空闲超时检测
+	lastActive atomic.Int64
+	// 最后一次读取到消息的时间(UnixNano)，包括心跳消息，用于心跳检测
+	lastRead atomic.Int64
+	// 时间轮上的活跃检测任务
+	keepaliveTimer atomic.Pointer[WheelTimer]
+	// 上一次心跳检测的时间，仅在检测任务中访问
+	lastBeat time.Time
+	// 下一次心跳检测的时间，仅在检测任务中访问
+	nextBeat time.Time
+	// 连续未收到消息的心跳次数，仅在检测任务中访问
+	missedBeats int
 
 	// 会话处理器
 	handler kiface.IHandler
-	// 连接心跳通道，读取到连接的数据后刷新一下心跳，表示处于活跃
-	active chan struct{}
 	// 消息输出通道，将要发送给本会话的数据添加到该通道内，由写协程读取并且发送给连接
 	outChannel chan kiface.IMessage
+	// 成功加入发送队列的消息数量
+	enqueued atomic.Uint64
+	// 发送队列丢弃的消息数量
+	dropped atomic.Uint64
+	// 发送队列深度的历史最大值
+	highWater atomic.Int64
+	// 写协程写入连接的次数
+	flushes atomic.Uint64
 	// 消息封包与解包处理器
 	packer kiface.IPacker
+	// 会话加入的分组名称
+	groups map[string]struct{}
+	// groups 的互斥锁
+	groupsLock sync.Mutex
 }
 
-// NewNormalSession 创建连接会话
-func NewNormalSession(id uint32, conn net.Conn, handler kiface.IHandler, ctx context.Context, cancel context.CancelFunc, isIdleTimeout bool, idleTimeout time.Duration) *NormalSession {
-	return &NormalSession{
+// NewNormalSession 创建连接会话，会话的处理器、路由器以及超时配置继承自所属的服务端
+func NewNormalSession(server *NormalServer, id uint32, conn net.Conn, packer kiface.IPacker, ctx context.Context, cancel context.CancelFunc) *NormalSession {
+	session := &NormalSession{
 		ID:            id,
 		Conn:          conn,
-		IsClosed:      false,
-		handler:       handler,
-		isIdleTimeout: isIdleTimeout,
-		idleTimeout:   idleTimeout,
+		reader:        bufio.NewReader(conn),
+		server:        server,
+		listener:      ListenerName(conn),
+		handler:       server.handler,
+		isIdleTimeout: server.isIdleTimeout,
+		idleTimeout:   server.idleTimeout,
 		context:       ctx,
 		cancel:        cancel,
-		outChannel:    make(chan kiface.IMessage, 16),
-		packer:        NewNormalPacker(),
+		readerDone:    make(chan struct{}),
+		flushing:      make(chan struct{}),
+		writerDone:    make(chan struct{}),
+		outChannel:    make(chan kiface.IMessage, server.writeQueueSize),
+		packer:        packer,
+		groups:        make(map[string]struct{}),
 	}
+	now := time.Now().UnixNano()
+	session.lastActive.Store(now)
+	session.lastRead.Store(now)
+	return session
 }
 
 // Rnu 启动会话
 func (ns *NormalSession) Rnu() {
-	// 启动3个协程，分别执行读、写任务以及心跳监控
+	// 启动2个协程，分别执行读、写任务，活跃检测由服务端的时间轮负责
 	go ns.Reader()
 	go ns.Writer()
-	if ns.isIdleTimeout {
-		ns.active = make(chan struct{})
-		go ns.idleTimeOuter()
-	}
+	ns.startKeepalive()
 }
 
 // Reader 连接会话的读任务,读取连接的数据，回调 onHandler 函数进行处理
 func (ns *NormalSession) Reader() {
 	fmt.Printf("[%s] Session ID: %d Reader Work Running... \n", ns.GetRemoteAddr(), ns.ID)
+	defer close(ns.readerDone)
 	// 循环读取数据
-	for {
+	for !ns.draining.Load() {
 		// 阻塞读取消息数据，直到:读取到足够的数据 | 读取超时 | 连接被关闭
 		message, err := ns.Read(time.Second * 3)
 		// 读取错误处理
 		if err != nil {
-			if err == io.EOF || ns.IsClosed {
-				// err == io.EOF 	 表示客户端主动关闭;
-				// IsClosed == true  表示服务端主动将客户端连接关闭;(超时|处理函数返回错误)
-				fmt.Printf("[%s] Session ID: %d Reader Work Shutdown... \n", ns.GetRemoteAddr(), ns.ID)
-				// 停止任务
-				ns.Stop()
-				return
-			} else if e, ok := err.(net.Error); ok && e.Timeout() {
+			if ns.draining.Load() {
+				// 会话正在优雅关闭，由关闭流程负责停止会话
+				break
+			}
+			if e, ok := err.(net.Error); ok && e.Timeout() {
 				// 本次读取数据超时
 				continue
 			}
-			// TODO 其他错误处理
+			if errors.Is(err, ErrFrameTooLarge) {
+				// 消息内容长度超过限制，连接中剩余的数据已无法解析，关闭连接
+				fmt.Printf("[%s] Session ID: %d %s \n", ns.GetRemoteAddr(), ns.ID, err.Error())
+				var reply kiface.IMessage
+				if ns.server.frameTooLargeReply {
+					reply = NewMessage(MsgIDFrameTooLarge, []byte(err.Error()))
+				}
+				// 由写协程写出已排队的响应以及错误消息后再关闭会话，关闭需等待读协程退出，因此在新协程中执行
+				ns.draining.Store(true)
+				go ns.closeAfterFlush(reply)
+				break
+			}
+			// err == io.EOF 	 表示客户端主动关闭;
+			// IsClose() == true 表示服务端主动将客户端连接关闭;(超时|处理函数返回错误)
+			// 其他错误表示连接已不可用，同样停止会话
+			if err != io.EOF && !ns.IsClose() {
+				fmt.Printf("[%s] Session ID: %d Read failed cause: %s \n", ns.GetRemoteAddr(), ns.ID, err.Error())
+			}
+			break
+		}
+		now := time.Now().UnixNano()
+		ns.lastRead.Store(now)
+		// 心跳消息由会话直接处理
+		if handleHeartbeat(ns, message) {
 			continue
 		}
+		ns.lastActive.Store(now)
 		// 读取到会话连接的数据，回调注册的处理函数链
-		if ns.handler != nil {
-			ctx := NewHandlerContext(ns, message, ns.context)
-			if err := ns.handler.OnHandler(ctx); err != nil {
-				ns.Stop()
-			}
+		if err := ns.server.dispatch(ns, message); err != nil {
+			ns.Stop()
+			break
 		}
 	}
+	fmt.Printf("[%s] Session ID: %d Reader Work Shutdown... \n", ns.GetRemoteAddr(), ns.ID)
+	if !ns.draining.Load() {
+		// 停止任务
+		ns.Stop()
+	}
 }
 
 // Writer 连接会话的写任务,读取会话的 outChannel 通道数据，将其写到客户端连接中.
+// 每次取出通道内已有的多个消息，合并为一次写入，减少系统调用次数.
 func (ns *NormalSession) Writer() {
 	fmt.Printf("[%s] Session ID: %d Writer Work Running... \n", ns.GetRemoteAddr(), ns.ID)
+	defer fmt.Printf("[%s] Session ID: %d Writer Work Shutdown... \n", ns.GetRemoteAddr(), ns.ID)
+	defer close(ns.writerDone)
+	var timer *time.Timer
+	if ns.server.writeBatchLatency > 0 {
+		timer = time.NewTimer(ns.server.writeBatchLatency)
+		timer.Stop()
+		defer timer.Stop()
+	}
+	batch := make([]kiface.IMessage, 0, ns.server.writeBatchSize)
 	for {
 		// 阻塞等待 从消息通道内获取消息，将消息写回到客户端
-		message, ok := <-ns.outChannel
-		// 消息通道已关闭，表示客户端连接已关闭，退出当前协程
-		if !ok && ns.IsClosed {
-			fmt.Printf("[%s] Session ID: %d Writer Work Shutdown... \n", ns.GetRemoteAddr(), ns.ID)
+		select {
+		case message := <-ns.outChannel:
+			// 将一批消息数据写入到客户端连接
+			batch = ns.collect(append(batch, message), timer)
+			_ = ns.writeBatch(batch)
+			batch = resetBatch(batch)
+		case <-ns.flushing:
+			// 会话优雅关闭，将通道内剩余的消息全部写入后退出
+			for {
+				if batch = ns.collect(batch, nil); len(batch) == 0 {
+					return
+				}
+				_ = ns.writeBatch(batch)
+				batch = resetBatch(batch)
+			}
+		case <-ns.context.Done():
+			// 会话已关闭，退出当前协程
 			return
 		}
-		// 将消息数据写入到客户端连接
-		_ = ns.Write(message)
 	}
 }
 
 // Send 将消息添加至会话通道，然后被写入到客户端连接中
-func (ns *NormalSession) Send(message kiface.IMessage) {
-	ns.outChannel <- message
+// 通道已满时按服务端配置的溢出策略处理，见 OverflowPolicy；会话已关闭时返回 ErrSessionClosed
+func (ns *NormalSession) Send(message kiface.IMessage) error {
+	return ns.enqueue(message, true)
 }
 
-// idleTimeOuter 会话的心跳检测器，超过指定时间未接到心跳则超时
-func (ns *NormalSession) idleTimeOuter() {
-	fmt.Printf("[%s] Session ID: %d Timeouter Running... \n", ns.GetRemoteAddr(), ns.ID)
-	defer fmt.Printf("[%s] Session ID: %d Timeouter Shutdown... \n", ns.GetRemoteAddr(), ns.ID)
-	for {
-		select {
-		case <-ns.active:
-			// 接收心跳信号，保持活跃
-		case <-ns.context.Done():
-			// 客户端已关闭，停止心跳发送
-			return
-		case <-time.After(ns.idleTimeout):
-			// 会话连接超时退出
-			_, _ = ns.Conn.Write([]byte("您超时了!"))
-			ns.Stop()
-			return
-		}
+// TrySend 尝试将消息添加至会话通道，用于组播等不可阻塞的场景
+// 通道已满时不会阻塞: 丢弃类策略与关闭策略照常执行，阻塞类策略直接丢弃本条消息；消息未加入通道时返回false
+func (ns *NormalSession) TrySend(message kiface.IMessage) bool {
+	return ns.enqueue(message, false) == nil
+}
+
+// Join 加入指定名称的分组
+func (ns *NormalSession) Join(group string) error {
+	ns.groupsLock.Lock()
+	if ns.IsClose() {
+		ns.groupsLock.Unlock()
+		return ErrSessionClosed
+	}
+	ns.groups[group] = struct{}{}
+	ns.groupsLock.Unlock()
+	// 在锁外加入分组，避免分组回调函数中访问会话时死锁
+	ns.server.groups.Join(group, ns)
+	if ns.IsClose() {
+		// 加入期间会话已关闭，确保不会残留在分组内
+		ns.server.groups.Leave(group, ns)
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// Leave 离开指定名称的分组
+func (ns *NormalSession) Leave(group string) {
+	ns.groupsLock.Lock()
+	_, ok := ns.groups[group]
+	delete(ns.groups, group)
+	ns.groupsLock.Unlock()
+	if ok {
+		ns.server.groups.Leave(group, ns)
+	}
+}
+
+// Groups 获取会话加入的所有分组名称
+func (ns *NormalSession) Groups() []string {
+	ns.groupsLock.Lock()
+	defer ns.groupsLock.Unlock()
+	groups := make([]string, 0, len(ns.groups))
+	for group := range ns.groups {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// leaveAll 离开所有分组
+func (ns *NormalSession) leaveAll() {
+	ns.groupsLock.Lock()
+	groups := ns.groups
+	ns.groups = make(map[string]struct{})
+	ns.groupsLock.Unlock()
+	for group := range groups {
+		ns.server.groups.Leave(group, ns)
 	}
 }
 
@@ -147,7 +289,7 @@ func (ns *NormalSession) Read(timeout time.Duration) (kiface.IMessage, error) {
 	// 设置本次读取数据的阻塞超时时间 3s
 	_ = ns.Conn.SetReadDeadline(time.Now().Add(timeout))
 	// 从连接中阻塞读取数据，并且解包为IMessage
-	return ns.packer.UnPack(ns.Conn)
+	return ns.packer.UnPack(ns.reader)
 }
 
 // Write 向客户端连接写入数据
@@ -157,9 +299,54 @@ func (ns *NormalSession) Write(message kiface.IMessage) error {
 	if err != nil {
 		return err
 	}
-	// 写入连接
+	// 写入连接，写入完成后归还数据包缓冲区
 	_, err = ns.Conn.Write(pack)
-	return err
+	ReleasePack(ns.packer, pack)
+	if err != nil {
+		return err
+	}
+	if !isHeartbeat(message) {
+		ns.lastActive.Store(time.Now().UnixNano())
+	}
+	return nil
+}
+
+// GetPrincipal 获取会话的认证主体
+func (ns *NormalSession) GetPrincipal() any {
+	return ns.principal
+}
+
+// GetPeerCertificates 获取客户端提供的证书，非TLS连接或客户端未提供证书时返回nil
+func (ns *NormalSession) GetPeerCertificates() []*x509.Certificate {
+	state, ok := TLSState(ns.Conn)
+	if !ok {
+		return nil
+	}
+	return state.PeerCertificates
+}
+
+// GetVerifiedChains 获取已验证的客户端证书链，仅在开启双向认证时有值
+func (ns *NormalSession) GetVerifiedChains() [][]*x509.Certificate {
+	state, ok := TLSState(ns.Conn)
+	if !ok {
+		return nil
+	}
+	return state.VerifiedChains
+}
+
+// GetPeerCred 获取 Unix 域套接字对端进程的凭证，非 Unix 域套接字连接或当前平台不支持时返回false
+func (ns *NormalSession) GetPeerCred() (PeerCred, bool) {
+	return PeerCredentials(ns.Conn)
+}
+
+// GetListenerName 获取会话的连接所属的监听器名称
+func (ns *NormalSession) GetListenerName() string {
+	return ns.listener
+}
+
+// GetPacker 获取会话使用的消息处理器
+func (ns *NormalSession) GetPacker() kiface.IPacker {
+	return ns.packer
 }
 
 // GetConn 获取会话的客户端连接
@@ -169,7 +356,7 @@ func (ns *NormalSession) Write(message kiface.IMessage) error {
 
 // GetSessionID 获取会话的ID
 func (ns *NormalSession) GetSessionID() uint32 {
-	return ns.GetSessionID()
+	return ns.ID
 }
 
 // GetRemoteAddr 获取客户端连接地址
@@ -179,24 +366,81 @@ func (ns *NormalSession) GetRemoteAddr() net.Addr {
 
 // Stop 关闭会话
 func (ns *NormalSession) Stop() {
-	// 防止重复关闭两次，因为如果是连接超时关闭，会执行两次该函数.
-	if !ns.IsClosed {
+	// 防止重复关闭，连接超时、处理函数返回错误以及服务端关闭都可能触发该函数.
+	ns.closeOnce.Do(func() {
 		// 将会话标记为已关闭
-		ns.IsClosed = true
-		// 关闭会话的消息通道，从而关闭写协程
-		close(ns.outChannel)
-		// 关闭会话上下文
+		ns.closed.Store(true)
+		// 关闭会话上下文，从而关闭写协程，并取消时间轮上的活跃检测
 		ns.cancel()
+		if timer := ns.keepaliveTimer.Load(); timer != nil {
+			timer.Stop()
+		}
 		// 执行 连接关闭的回调函数
-		_ = ns.handler.OnClosedHandler(ns.Conn)
+		if ns.handler != nil {
+			_ = ns.handler.OnClosedHandler(ns.Conn)
+		}
 		// 关闭客户端连接
 		_ = ns.Conn.Close()
+		// 离开所有分组，并从会话管理器移除会话
+		ns.leaveAll()
+		ns.server.sessions.Remove(ns)
+	})
+}
+
+// shutdown 优雅关闭会话: 发送告别消息，等待正在处理的消息处理完毕，将通道内剩余的消息写入连接后关闭会话
+// ctx 到期时直接返回，由调用方强制关闭会话
+func (ns *NormalSession) shutdown(ctx context.Context, goodbye kiface.IMessage) {
+	if ns.IsClose() {
+		return
+	}
+	if goodbye != nil {
+		_ = ns.Send(goodbye)
+	}
+	ns.flush(ctx)
+}
+
+// flush 停止读取新的消息，等待读协程退出后通知写协程写出队列中剩余的消息，全部写出后关闭会话
+// ctx 到期时直接返回，由调用方强制关闭会话
+func (ns *NormalSession) flush(ctx context.Context) {
+	// 停止读取新的消息，并且唤醒阻塞中的读操作
+	ns.draining.Store(true)
+	_ = ns.Conn.SetReadDeadline(time.Now())
+	select {
+	case <-ns.readerDone:
+	case <-ctx.Done():
+		return
+	}
+	// 读协程退出后不会再产生新的响应，通知写协程刷新剩余消息
+	ns.flushOnce.Do(func() {
+		close(ns.flushing)
+	})
+	select {
+	case <-ns.writerDone:
+	case <-ctx.Done():
+		return
+	}
+	ns.Stop()
+}
+
+// closeAfterFlush 将消息(可为nil)加入发送队列，由写协程在队列中已有的消息之后写出，写出后关闭会话
+// 消息不会绕过写协程直接写入连接，避免与正在进行的批量写入交错；超过 closeFlushTimeout 时强制关闭会话
+func (ns *NormalSession) closeAfterFlush(message kiface.IMessage) {
+	if ns.IsClose() {
+		return
+	}
+	if message != nil {
+		// 队列已满说明客户端已不再读取，放弃发送该消息
+		_ = ns.TrySend(message)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), closeFlushTimeout)
+	defer cancel()
+	ns.flush(ctx)
+	ns.Stop()
 }
 
 // IsClose 会话是否已关闭
 func (ns *NormalSession) IsClose() bool {
-	return ns.IsClosed
+	return ns.closed.Load()
 }
 
 // GetContext 获取会话的上下文
@@ -0,0 +1,92 @@
+// @Title session_manager.go
+// @Description 会话管理器实现
+// @Author Zero - 2023/10/2 10:36:52
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"sync"
+)
+
+// SessionManager 并发安全的会话管理器
+// 会话建立后由服务端添加，会话关闭(Stop)时自动移除.
+type SessionManager struct {
+	// 存活的会话，key为会话ID
+	sessions map[uint32]kiface.ISession
+	// sessions 的读写锁
+	lock sync.RWMutex
+}
+
+// NewSessionManager 创建会话管理器
+func NewSessionManager() kiface.ISessionManager {
+	return &SessionManager{
+		sessions: make(map[uint32]kiface.ISession),
+	}
+}
+
+// Add 添加会话
+func (sm *SessionManager) Add(session kiface.ISession) {
+	sm.lock.Lock()
+	sm.sessions[session.GetSessionID()] = session
+	sm.lock.Unlock()
+}
+
+// Remove 移除会话
+func (sm *SessionManager) Remove(session kiface.ISession) {
+	sm.lock.Lock()
+	// 仅当ID对应的仍是该会话时才移除
+	if s, ok := sm.sessions[session.GetSessionID()]; ok && s == session {
+		delete(sm.sessions, session.GetSessionID())
+	}
+	sm.lock.Unlock()
+}
+
+// Get 根据会话ID获取会话
+func (sm *SessionManager) Get(id uint32) (kiface.ISession, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	session, ok := sm.sessions[id]
+	return session, ok
+}
+
+// Range 遍历所有会话，fn 返回false时停止遍历
+// 遍历的是会话的快照，fn 内可以安全地关闭会话
+func (sm *SessionManager) Range(fn func(session kiface.ISession) bool) {
+	for _, session := range sm.snapshot() {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// Count 获取存活的会话数量
+func (sm *SessionManager) Count() int {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return len(sm.sessions)
+}
+
+// Broadcast 向所有满足 filter 的会话发送消息，filter 为nil时发送给所有会话，返回消息送达的会话数量
+// 发送队列已满的慢速会话按溢出策略处理(默认丢弃本条消息)，不会阻塞其他会话
+func (sm *SessionManager) Broadcast(message kiface.IMessage, filter func(session kiface.ISession) bool) int {
+	delivered := 0
+	sm.Range(func(session kiface.ISession) bool {
+		if (filter == nil || filter(session)) && trySend(session, message) {
+			delivered++
+		}
+		return true
+	})
+	return delivered
+}
+
+// snapshot 获取当前存活会话的快照
+func (sm *SessionManager) snapshot() []kiface.ISession {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	sessions := make([]kiface.ISession, 0, len(sm.sessions))
+	for _, session := range sm.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
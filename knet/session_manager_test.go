@@ -0,0 +1,96 @@
+// @Title session_manager_test.go
+// @Description 会话管理器的广播、过滤以及会话关闭时自动移除的测试
+// @Author Zero - 2023/10/22 20:31:54
+
+package knet
+
+import (
+	"context"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"testing"
+)
+
+// newMemberSession 在 server 上创建未启动读写协程的会话并添加至会话管理器，发送的消息保留在发送队列中
+func newMemberSession(t *testing.T, server *NormalServer, id uint32) *NormalSession {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { _ = peer.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	session := NewNormalSession(server, id, conn, server.packer, ctx, cancel)
+	server.sessions.Add(session)
+	t.Cleanup(session.Stop)
+	return session
+}
+
+// newMemberServer 创建用于 newMemberSession 的服务端，服务端不会运行
+func newMemberServer(t *testing.T, opts ...NormalServerOption) *NormalServer {
+	t.Helper()
+	server := NewNormalServer(opts...).(*NormalServer)
+	t.Cleanup(server.pool.Release)
+	return server
+}
+
+func TestSessionManagerBroadcast(t *testing.T) {
+	server := newMemberServer(t)
+	sessions := []*NormalSession{
+		newMemberSession(t, server, 1),
+		newMemberSession(t, server, 2),
+		newMemberSession(t, server, 3),
+	}
+	manager := server.GetSessionManager()
+	if delivered := manager.Broadcast(NewMessage(1, nil), nil); delivered != 3 {
+		t.Fatalf("broadcast delivered %d, want 3", delivered)
+	}
+	// 只发送给满足 filter 的会话
+	odd := func(session kiface.ISession) bool { return session.GetSessionID()%2 == 1 }
+	if delivered := manager.Broadcast(NewMessage(2, nil), odd); delivered != 2 {
+		t.Fatalf("filtered broadcast delivered %d, want 2", delivered)
+	}
+	for i, want := range []int{2, 1, 2} {
+		if queued := len(sessions[i].outChannel); queued != want {
+			t.Fatalf("session %d queued %d messages, want %d", i+1, queued, want)
+		}
+	}
+}
+
+func TestSessionManagerRemoveOnStop(t *testing.T) {
+	server := newMemberServer(t)
+	first := newMemberSession(t, server, 1)
+	newMemberSession(t, server, 2)
+	manager := server.GetSessionManager()
+	first.Stop()
+	if _, ok := manager.Get(1); ok || manager.Count() != 1 {
+		t.Fatalf("stopped session still managed, count %d", manager.Count())
+	}
+	// 已关闭的会话不会移除使用相同ID的新会话
+	replacement := newMemberSession(t, server, 2)
+	server.sessions.Remove(first)
+	if session, ok := manager.Get(2); !ok || session != replacement {
+		t.Fatal("replacement session removed")
+	}
+}
+
+func TestSessionManagerStopDuringBroadcast(t *testing.T) {
+	server := newMemberServer(t)
+	victim := newMemberSession(t, server, 1)
+	newMemberSession(t, server, 2)
+	newMemberSession(t, server, 3)
+	manager := server.GetSessionManager()
+	// 遍历到 victim 时将其关闭: 广播使用快照，关闭的会话不计入送达数量，其他会话不受影响
+	delivered := manager.Broadcast(NewMessage(1, nil), func(session kiface.ISession) bool {
+		if session == kiface.ISession(victim) {
+			victim.Stop()
+		}
+		return true
+	})
+	if delivered != 2 {
+		t.Fatalf("broadcast delivered %d, want 2", delivered)
+	}
+	if manager.Count() != 2 {
+		t.Fatalf("count %d after stop, want 2", manager.Count())
+	}
+	if delivered = manager.Broadcast(NewMessage(2, nil), nil); delivered != 2 {
+		t.Fatalf("broadcast after stop delivered %d, want 2", delivered)
+	}
+}
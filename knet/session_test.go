@@ -0,0 +1,60 @@
+// @Title session_test.go
+// @Description 会话读取超过长度限制的消息时，写出已排队的响应与错误消息后关闭连接
+// @Author Zero - 2023/10/22 19:33:18
+
+package knet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameTooLarge(t *testing.T) {
+	for _, reply := range []bool{true, false} {
+		opts := []NormalServerOption{WithHandler(&testEchoHandler{}), WithMaxPayloadSize(16)}
+		if reply {
+			opts = append(opts, WithFrameTooLargeReply())
+		}
+		_, addr := startTestServer(t, ListenerConfig{}, opts...)
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+		// 正常的请求之后紧跟一个超过长度限制的消息
+		packer := NewNormalPacker()
+		small, _ := packer.Pack(NewMessage(1, []byte("hi")))
+		large, _ := packer.Pack(NewMessage(1, bytes.Repeat([]byte{'k'}, 64)))
+		if _, err = conn.Write(append(append([]byte{}, small...), large...)); err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		echo, err := packer.UnPack(reader)
+		if err != nil {
+			t.Fatalf("reply %v: read echo: %v", reply, err)
+		}
+		if string(echo.Payload()) != "echo:hi" {
+			t.Fatalf("reply %v: got %q, want echo:hi", reply, echo.Payload())
+		}
+		if reply {
+			message, err := packer.UnPack(reader)
+			if err != nil {
+				t.Fatalf("read frame too large reply: %v", err)
+			}
+			if message.ID() != MsgIDFrameTooLarge {
+				t.Fatalf("got message ID %d, want MsgIDFrameTooLarge", message.ID())
+			}
+		}
+		// 随后连接被正常关闭
+		if _, err = packer.UnPack(reader); !errors.Is(err, io.EOF) {
+			t.Fatalf("reply %v: read after close got %v, want io.EOF", reply, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// @Title timing_wheel.go
+// @Description 分层时间轮，用于大量会话的空闲超时与心跳检测
+// @Author Zero - 2023/10/20 14:08:51
+
+package knet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 时间轮每层槽位数量的位数，每层 64 个槽位
+	wheelBits = 6
+	// 时间轮每层的槽位数量
+	wheelSlots = 1 << wheelBits
+	// 时间轮每层槽位的掩码
+	wheelMask = wheelSlots - 1
+	// 时间轮的层数，最大延迟为 64^4 个刻度
+	wheelLevels = 4
+	// 时间轮可表示的最大延迟刻度数
+	wheelMaxTicks = 1<<(wheelBits*wheelLevels) - 1
+	// 默认的时间轮刻度
+	defaultWheelTick = 100 * time.Millisecond
+)
+
+// WheelTimer 时间轮定时任务
+type WheelTimer struct {
+	// 到期的刻度
+	expire uint64
+	// 到期后执行的函数
+	fn func()
+	// 是否已取消
+	stopped atomic.Bool
+}
+
+// Stop 取消定时任务，已取消的任务到期后不再执行
+func (t *WheelTimer) Stop() {
+	t.stopped.Store(true)
+}
+
+// TimingWheel 分层时间轮，所有会话共用一个协程与一个 time.Ticker
+// 共 4 层，每层 64 个槽位: 第0层每个槽位为 1 个刻度，第 n 层每个槽位为 64^n 个刻度，
+// 低层转完一圈时将高层对应槽位的任务降级至低层; 超过最大延迟的任务按最大延迟处理.
+// 同一刻度内到期的任务在一个新协程中批量顺序执行，不会阻塞时间轮的推进.
+type TimingWheel struct {
+	// 刻度时长，任务的到期精度
+	tick time.Duration
+	// 各层的槽位
+	buckets [wheelLevels][wheelSlots][]*WheelTimer
+	// 已推进的刻度数
+	current uint64
+	// 启动时间
+	start time.Time
+	// buckets 以及 current 的互斥锁
+	lock sync.Mutex
+	// 停止信号
+	stop chan struct{}
+	// 保证只停止一次
+	stopOnce sync.Once
+}
+
+// NewTimingWheel 创建时间轮，创建后需调用 Start 启动
+// @param	tick	刻度时长，<= 0 时使用默认值 100ms
+func NewTimingWheel(tick time.Duration) *TimingWheel {
+	if tick <= 0 {
+		tick = defaultWheelTick
+	}
+	return &TimingWheel{
+		tick:  tick,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+	}
+}
+
+// wheelTick 根据超时时间选择时间轮刻度，刻度不超过最短超时时间的 1/10，且在 1ms 与默认值之间
+func wheelTick(timeouts ...time.Duration) time.Duration {
+	tick := defaultWheelTick
+	for _, timeout := range timeouts {
+		if timeout > 0 && timeout/10 < tick {
+			tick = timeout / 10
+		}
+	}
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	return tick
+}
+
+// Start 启动时间轮
+func (tw *TimingWheel) Start() {
+	go tw.run()
+}
+
+// Stop 停止时间轮，未到期的任务不再执行
+func (tw *TimingWheel) Stop() {
+	tw.stopOnce.Do(func() {
+		close(tw.stop)
+	})
+}
+
+// AfterFunc 在 delay 后执行 fn，fn 在时间轮的批量任务协程中执行，不应长时间阻塞
+func (tw *TimingWheel) AfterFunc(delay time.Duration, fn func()) *WheelTimer {
+	// 按实际经过的时间计算到期刻度并向上取整，保证不会提前执行
+	expire := uint64((time.Since(tw.start) + delay + tw.tick - 1) / tw.tick)
+	timer := &WheelTimer{fn: fn}
+	tw.lock.Lock()
+	if expire <= tw.current {
+		expire = tw.current + 1
+	}
+	if expire-tw.current > wheelMaxTicks {
+		expire = tw.current + wheelMaxTicks
+	}
+	timer.expire = expire
+	tw.add(timer)
+	tw.lock.Unlock()
+	return timer
+}
+
+// add 根据剩余刻度数将任务放入对应层的槽位，调用方需持有锁
+func (tw *TimingWheel) add(timer *WheelTimer) {
+	delta := timer.expire - tw.current
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	slot := (timer.expire >> (wheelBits * level)) & wheelMask
+	tw.buckets[level][slot] = append(tw.buckets[level][slot], timer)
+}
+
+// run 时间轮的推进协程
+func (tw *TimingWheel) run() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// 追赶因调度延迟而错过的刻度
+			target := uint64(now.Sub(tw.start) / tw.tick)
+			var due []*WheelTimer
+			tw.lock.Lock()
+			for tw.current < target {
+				due = append(due, tw.advance()...)
+			}
+			tw.lock.Unlock()
+			if len(due) > 0 {
+				go execute(due)
+			}
+		case <-tw.stop:
+			return
+		}
+	}
+}
+
+// advance 推进一个刻度，返回到期的任务，调用方需持有锁
+func (tw *TimingWheel) advance() []*WheelTimer {
+	tw.current++
+	// 找到本次需要降级的最高层: 低层的槽位索引回到0时，高层推进了一个槽位
+	top := 0
+	for level := 1; level < wheelLevels; level++ {
+		if (tw.current>>(wheelBits*level-wheelBits))&wheelMask != 0 {
+			break
+		}
+		top = level
+	}
+	// 由高到低降级，保证降级后的任务能够继续降级至正确的层
+	for level := top; level > 0; level-- {
+		slot := (tw.current >> (wheelBits * level)) & wheelMask
+		timers := tw.buckets[level][slot]
+		tw.buckets[level][slot] = nil
+		for _, timer := range timers {
+			tw.add(timer)
+		}
+	}
+	slot := tw.current & wheelMask
+	due := tw.buckets[0][slot]
+	tw.buckets[0][slot] = nil
+	return due
+}
+
+// execute 批量执行到期的任务
+func execute(timers []*WheelTimer) {
+	for _, timer := range timers {
+		if !timer.stopped.Load() {
+			timer.fn()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// @Title timing_wheel_test.go
+// @Description 分层时间轮的跨层降级、取消以及实际到期时间的测试
+// @Author Zero - 2023/10/22 18:57:04
+
+package knet
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scheduleAt 在第 expire 个刻度注册任务，绕过按实际时间计算到期刻度，用于逐刻度推进的测试
+func scheduleAt(tw *TimingWheel, expire uint64, fn func()) *WheelTimer {
+	timer := &WheelTimer{expire: expire, fn: fn}
+	tw.lock.Lock()
+	tw.add(timer)
+	tw.lock.Unlock()
+	return timer
+}
+
+// stepUntil 逐刻度推进时间轮并执行到期的任务，直到 done 返回true或推进至 limit 刻度
+func stepUntil(tw *TimingWheel, limit uint64, done func() bool) {
+	for tw.current < limit && !done() {
+		tw.lock.Lock()
+		due := tw.advance()
+		tw.lock.Unlock()
+		execute(due)
+	}
+}
+
+func TestTimingWheelCascade(t *testing.T) {
+	delays := []uint64{
+		1, wheelMask, wheelSlots, wheelSlots + 1,
+		wheelSlots*wheelSlots - 1, wheelSlots * wheelSlots, wheelSlots*wheelSlots + 1,
+		wheelSlots*wheelSlots*wheelSlots + 5, 2*wheelSlots*wheelSlots*wheelSlots + 9,
+	}
+	// 从不同的起始刻度出发，覆盖未对齐槽位边界时的降级
+	for _, start := range []uint64{0, 100, wheelSlots*wheelSlots - 3} {
+		for _, delay := range delays {
+			tw := NewTimingWheel(time.Hour)
+			tw.current = start
+			expire := start + delay
+			var firedAt uint64
+			scheduleAt(tw, expire, func() { firedAt = tw.current })
+			stepUntil(tw, expire+wheelSlots, func() bool { return firedAt != 0 })
+			if firedAt != expire {
+				t.Fatalf("start %d delay %d: fired at tick %d, want %d", start, delay, firedAt, expire)
+			}
+		}
+	}
+}
+
+func TestTimingWheelStop(t *testing.T) {
+	tw := NewTimingWheel(time.Hour)
+	var fired, stopped atomic.Int32
+	scheduleAt(tw, wheelSlots+3, func() { fired.Add(1) })
+	scheduleAt(tw, wheelSlots+3, func() { stopped.Add(1) }).Stop()
+	// 降级后再取消同样生效
+	late := scheduleAt(tw, wheelSlots*wheelSlots+7, func() { stopped.Add(1) })
+	stepUntil(tw, wheelSlots*2, func() bool { return false })
+	late.Stop()
+	stepUntil(tw, wheelSlots*wheelSlots*2, func() bool { return false })
+	if fired.Load() != 1 || stopped.Load() != 0 {
+		t.Fatalf("fired %d stopped %d, want 1 and 0", fired.Load(), stopped.Load())
+	}
+}
+
+func TestTimingWheelMaxDelay(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond)
+	timer := tw.AfterFunc(24*time.Hour*365, func() {})
+	if timer.expire != tw.current+wheelMaxTicks {
+		t.Fatalf("expire %d, want clamped to %d", timer.expire, tw.current+wheelMaxTicks)
+	}
+}
+
+func TestTimingWheelFiringTime(t *testing.T) {
+	const tick = 5 * time.Millisecond
+	tw := NewTimingWheel(tick)
+	tw.Start()
+	defer tw.Stop()
+	// 延迟超过第0层的跨度(64 个刻度)，需经过一次降级
+	delays := []time.Duration{3 * tick, wheelSlots*tick + 40*time.Millisecond}
+	for _, delay := range delays {
+		fired := make(chan time.Time, 1)
+		start := time.Now()
+		tw.AfterFunc(delay, func() { fired <- time.Now() })
+		canceled := make(chan struct{}, 1)
+		tw.AfterFunc(delay, func() { canceled <- struct{}{} }).Stop()
+		select {
+		case at := <-fired:
+			if elapsed := at.Sub(start); elapsed < delay || elapsed > delay+20*tick {
+				t.Fatalf("delay %s fired after %s", delay, elapsed)
+			}
+		case <-time.After(delay + time.Second):
+			t.Fatalf("delay %s never fired", delay)
+		}
+		select {
+		case <-canceled:
+			t.Fatalf("delay %s: stopped timer fired", delay)
+		case <-time.After(2 * tick):
+		}
+	}
+}
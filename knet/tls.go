@@ -0,0 +1,110 @@
+// @Title tls.go
+// @Description TLS 以及双向 TLS 支持
+// @Author Zero - 2023/10/9 16:48:05
+
+package knet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// 默认的TLS握手超时时间
+const defaultHandshakeTimeout = time.Second * 10
+
+// TLS版本名称与版本号的映射
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// build 根据配置文件构建 tls.Config
+func (c *tlsConfig) build() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls key pair failed: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	// 最低TLS版本
+	if len(c.MinVersion) > 0 {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls min_version: %s", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	// 加密套件
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure tls cipher suite: %s", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	// 双向认证
+	if len(c.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls client ca failed: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client ca file: %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// handshaker 收发消息前需要先完成握手的连接，如 *tls.Conn 以及 WebSocket 连接
+type handshaker interface {
+	HandshakeContext(ctx context.Context) error
+}
+
+// netConner 包装了底层连接的连接，如 *tls.Conn 以及 WebSocket 连接
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// handshake 在超时时间内完成连接的握手，握手完成后才能获取客户端证书等信息；无需握手的连接直接返回
+func handshake(conn net.Conn, timeout time.Duration) error {
+	h, ok := conn.(handshaker)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return h.HandshakeContext(ctx)
+}
+
+// TLSState 获取连接的TLS状态，非TLS连接返回false
+// 可在 IHandler.OnConnectHandler 中通过 PeerCertificates 或 VerifiedChains 对客户端证书进行鉴权
+func TLSState(conn net.Conn) (tls.ConnectionState, bool) {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return c.ConnectionState(), true
+		case netConner:
+			conn = c.NetConn()
+		default:
+			return tls.ConnectionState{}, false
+		}
+	}
+}
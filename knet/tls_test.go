@@ -0,0 +1,148 @@
+// @Title tls_test.go
+// @Description 双向 TLS 测试，证书在测试中使用 crypto/x509 生成
+// @Author Zero - 2023/10/22 15:36:18
+
+package knet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/zlx2019/kinx/kiface"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert 测试证书以及签发它的私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// tlsCertificate 转换为 tls.Certificate
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// newTestCert 生成证书，parent 为nil时生成自签名的CA证书
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// peerCertHandler 以客户端证书的 CommonName 响应，并记录会话获取到的客户端证书
+type peerCertHandler struct {
+	kiface.SuperHandler
+	peers chan []*x509.Certificate
+}
+
+func (h *peerCertHandler) OnHandler(ctx kiface.IHandlerContext) error {
+	certs := ctx.GetSession().(*NormalSession).GetPeerCertificates()
+	h.peers <- certs
+	if len(certs) == 0 {
+		return ctx.Reply(nil)
+	}
+	return ctx.Reply([]byte(certs[0].Subject.CommonName))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "kinx-ca", nil, 0)
+	serverCert := newTestCert(t, "kinx-server", ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, "kinx-client", ca, x509.ExtKeyUsageClientAuth)
+	otherCA := newTestCert(t, "other-ca", nil, 0)
+	strangerCert := newTestCert(t, "stranger", otherCA, x509.ExtKeyUsageClientAuth)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	handler := &peerCertHandler{peers: make(chan []*x509.Certificate, 4)}
+	_, addr := startTestServer(t, ListenerConfig{
+		Name: "tls",
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}, WithHandler(handler))
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	dial := func(certs ...tls.Certificate) (kiface.IMessage, error) {
+		conn, err := tls.Dial("tcp", addr.String(), &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: certs,
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return testCall(conn, NewMessage(1, []byte("hello")))
+	}
+
+	t.Run("valid client", func(t *testing.T) {
+		reply, err := dial(clientCert.tlsCertificate())
+		if err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		if string(reply.Payload()) != "kinx-client" {
+			t.Fatalf("reply = %q, want kinx-client", reply.Payload())
+		}
+		peers := <-handler.peers
+		if len(peers) == 0 || !bytes.Equal(peers[0].Raw, clientCert.cert.Raw) {
+			t.Fatal("GetPeerCertificates does not return the client leaf certificate")
+		}
+	})
+	t.Run("no client cert", func(t *testing.T) {
+		if reply, err := dial(); err == nil {
+			t.Fatalf("expected handshake failure, got reply %q", reply.Payload())
+		}
+	})
+	t.Run("wrong CA", func(t *testing.T) {
+		if reply, err := dial(strangerCert.tlsCertificate()); err == nil {
+			t.Fatalf("expected handshake failure, got reply %q", reply.Payload())
+		}
+	})
+	if len(handler.peers) != 0 {
+		t.Fatal("rejected clients reached the handler")
+	}
+}
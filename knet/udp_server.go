@@ -0,0 +1,295 @@
+// @Title udp_server.go
+// @Description UDP 服务端实现，与 NormalServer 共用处理器、路由与消息处理器
+// @Author Zero - 2023/10/13 14:20:51
+
+package knet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// UDP 数据报的最大长度
+	maxDatagramSize = 64 * 1024
+	// UDP 会话收件箱的容量，超过后丢弃新到达的消息
+	udpInboxSize = 64
+	// 未设置 WithIdleTimeout 时 UDP 会话的空闲过期时间
+	defaultUDPIdleTimeout = 60 * time.Second
+	// 未设置 WithMaxConn 时 UDP 会话的最大数量
+	// 每个会话在存活期间占用一个协程，远端地址可以伪造，因此UDP服务端总是限制会话数量
+	defaultUDPMaxSessions = 4096
+)
+
+var (
+	// errUDPReadTimeout 读取UDP会话收件箱超时
+	errUDPReadTimeout = errors.New("knet: udp session read timeout")
+	// errUDPConnRead UDP会话的连接视图不支持直接读取
+	errUDPConnRead = errors.New("knet: udp session conn is not readable")
+)
+
+// UDPServer UDP 服务端，将每个远端地址视为一个伪会话
+// 复用 NormalServer 的配置项: 处理器、路由、中间件、消息处理器、认证器、最大连接数(未设置时为 4096)与空闲超时，
+// WithPackerFactory 与 TLS 相关配置不适用于UDP，所有会话共用 WithPacker 设置的消息处理器;
+// 每个数据报可以包含一个或多个完整的数据包，无法解包的数据报将被丢弃.
+type UDPServer struct {
+	// 共用配置的基础服务端
+	base *NormalServer
+	// UDP 连接
+	conn *net.UDPConn
+	// 存活的会话，key为远端地址
+	sessions map[string]*UDPSession
+	// sessions 的互斥锁
+	lock sync.Mutex
+	// 会话空闲过期时间
+	idleTimeout time.Duration
+	// 会话的最大数量
+	maxSessions int
+}
+
+// NewUDPServer 创建UDP服务端
+// @param	opts	服务配置，与 NewNormalServer 相同
+func NewUDPServer(opts ...NormalServerOption) kiface.IServer {
+	base := NewNormalServer(opts...).(*NormalServer)
+	base.protocol = "udp"
+	server := &UDPServer{
+		base:        base,
+		sessions:    make(map[string]*UDPSession),
+		idleTimeout: defaultUDPIdleTimeout,
+		maxSessions: defaultUDPMaxSessions,
+	}
+	if base.isIdleTimeout {
+		server.idleTimeout = base.idleTimeout
+	}
+	if base.maxConn > 0 {
+		server.maxSessions = base.maxConn
+	}
+	// UDP 会话总是需要空闲过期，未开启空闲超时时同样创建时间轮
+	if base.wheel == nil {
+		base.wheel = NewTimingWheel(wheelTick(server.idleTimeout))
+	}
+	return server
+}
+
+// Run 运行服务，并且阻塞接收数据报
+func (u *UDPServer) Run() error {
+	if err := u.ready(); err != nil {
+		fmt.Println("udp server ready failed cause: ", err.Error())
+		return err
+	}
+	u.base.isRunning.Store(true)
+	u.base.wheel.Start()
+	fmt.Printf("%s running successful. address in: %s \n", u.base.name, u.conn.LocalAddr().String())
+
+	// 开启协程任务，接收数据报
+	_ = u.base.pool.Submit(u.start)
+
+	// 阻塞等待服务关闭
+	<-u.base.stopTrigger
+	fmt.Printf("%s shutodwn successful. \n", u.base.name)
+	return nil
+}
+
+// 创建UDP网络服务
+func (u *UDPServer) ready() error {
+	if u.base.isRunning.Load() {
+		panic("server already running")
+	}
+	udpAddr, err := net.ResolveUDPAddr(u.base.protocol, u.base.listenAddress())
+	if err != nil {
+		return err
+	}
+	u.conn, err = net.ListenUDP(u.base.protocol, udpAddr)
+	return err
+}
+
+// 循环接收数据报，解包后投递至对应远端地址的会话
+func (u *UDPServer) start() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, remote, err := u.conn.ReadFromUDP(buf)
+		if err != nil {
+			if u.base.closing.Load() {
+				// 服务端关闭，连接已关闭，退出循环
+				return
+			}
+			continue
+		}
+		messages, err := u.unpack(buf[:n])
+		if err != nil {
+			fmt.Printf("[%s] 数据报解包失败: %s \n", remote, err.Error())
+			continue
+		}
+		session := u.session(remote)
+		if session == nil {
+			continue
+		}
+		for _, message := range messages {
+			session.deliver(message)
+		}
+	}
+}
+
+// unpack 将数据报解包为消息，数据报中须只包含完整的数据包
+func (u *UDPServer) unpack(datagram []byte) ([]kiface.IMessage, error) {
+	reader := bytes.NewReader(datagram)
+	var messages []kiface.IMessage
+	for reader.Len() > 0 {
+		message, err := u.base.packer.UnPack(reader)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// session 获取远端地址对应的会话，不存在时创建会话
+// 超过最大会话数量、协程池已满或服务端关闭中时返回nil，丢弃本次数据报.
+// 仅由接收数据报的协程调用，因此创建会话的过程无需持有锁.
+func (u *UDPServer) session(remote *net.UDPAddr) *UDPSession {
+	key := remote.String()
+	u.lock.Lock()
+	session, ok := u.sessions[key]
+	count := len(u.sessions)
+	u.lock.Unlock()
+	if ok {
+		return session
+	}
+	if u.base.closing.Load() {
+		return nil
+	}
+	if count >= u.maxSessions {
+		u.reject(remote)
+		return nil
+	}
+	// 协程池已满时丢弃数据报，不能阻塞接收数据报的协程
+	// 接收协程是唯一提交任务的协程，存在空闲协程时 Submit 不会阻塞
+	if u.base.pool.Free() == 0 {
+		return nil
+	}
+	id := atomic.AddUint32(&u.base.nextSessionID, 1) - 1
+	session = newUDPSession(u, id, remote)
+	// 回调连接建立事件处理函数，获取自定义的会话的上下文
+	ctx := context.Background()
+	if u.base.handler != nil {
+		ctx = u.base.handler.OnConnectHandler(session.conn)
+	}
+	if session.IsClose() {
+		// 连接建立事件中已关闭连接，拒绝该远端地址
+		return nil
+	}
+	session.context, session.cancel = context.WithCancel(ctx)
+	u.lock.Lock()
+	if u.base.closing.Load() {
+		u.lock.Unlock()
+		session.cancel()
+		return nil
+	}
+	u.sessions[key] = session
+	u.lock.Unlock()
+	if err := u.base.pool.Submit(func() { u.serve(session) }); err != nil {
+		u.lock.Lock()
+		delete(u.sessions, key)
+		u.lock.Unlock()
+		session.cancel()
+		return nil
+	}
+	session.scheduleExpiry()
+	return session
+}
+
+// serve 认证会话后注册至会话管理器，并且开始处理会话的消息
+func (u *UDPServer) serve(session *UDPSession) {
+	principal, err := u.base.authenticate(session)
+	if err != nil {
+		fmt.Printf("[%s] 会话建立失败: %s \n", session.GetRemoteAddr(), err.Error())
+		session.Stop()
+		return
+	}
+	session.principal = principal
+	u.base.sessions.Add(session)
+	if session.IsClose() {
+		// 认证期间会话已被关闭(空闲过期或服务端关闭)
+		u.base.sessions.Remove(session)
+		return
+	}
+	fmt.Printf("Conn session successful. ID of: %d \n", session.ID)
+	session.Reader()
+}
+
+// removeSession 移除会话，由 UDPSession.Stop 调用
+func (u *UDPServer) removeSession(session *UDPSession) {
+	key := session.remote.String()
+	u.lock.Lock()
+	if s, ok := u.sessions[key]; ok && s == session {
+		delete(u.sessions, key)
+	}
+	u.lock.Unlock()
+	u.base.sessions.Remove(session)
+}
+
+// reject 拒绝会话，通知客户端服务繁忙
+func (u *UDPServer) reject(remote *net.UDPAddr) {
+	fmt.Printf("[%s] 超过最大连接数: %d，拒绝连接 \n", remote, u.maxSessions)
+	pack, err := u.base.packer.Pack(NewMessage(MsgIDServerBusy, []byte("server busy")))
+	if err == nil {
+		_, _ = u.conn.WriteToUDP(pack, remote)
+	}
+}
+
+// snapshot 获取所有会话的快照
+func (u *UDPServer) snapshot() []*UDPSession {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	sessions := make([]*UDPSession, 0, len(u.sessions))
+	for _, session := range u.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// GetSessionManager 获取会话管理器，用于查找、遍历会话以及广播消息
+func (u *UDPServer) GetSessionManager() kiface.ISessionManager {
+	return u.base.sessions
+}
+
+// GetGroupManager 获取会话分组管理器，用于组播消息以及注册分组成员变更回调
+func (u *UDPServer) GetGroupManager() kiface.IGroupManager {
+	return u.base.groups
+}
+
+// Shutdown 关闭服务
+// 停止创建新的会话，向所有会话发送告别消息，处理完收件箱中剩余的消息后关闭会话，并释放协程池.
+// ctx 到期后强制关闭剩余的会话.
+func (u *UDPServer) Shutdown(ctx context.Context) error {
+	if !u.base.isRunning.Load() || !u.base.closing.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+	// 优雅关闭已认证的会话，认证中的会话直接关闭
+	err := drainSessions(ctx, u.base.sessions, u.base.goodbye)
+	for _, session := range u.snapshot() {
+		session.Stop()
+	}
+	// 停止接收数据报
+	if closeErr := u.conn.Close(); err == nil {
+		err = closeErr
+	}
+	u.base.wheel.Stop()
+	u.base.pool.Release()
+	u.base.isRunning.Store(false)
+	// 唤醒 Run
+	close(u.base.stopTrigger)
+	return err
+}
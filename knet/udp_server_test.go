@@ -0,0 +1,152 @@
+// @Title udp_server_test.go
+// @Description UDP 伪会话的创建、回复路由、空闲过期以及连接建立事件中拒绝远端地址的测试
+// @Author Zero - 2023/10/22 17:31:05
+
+package knet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startUDPTestServer 在后台运行UDP服务端，返回服务端以及监听地址，测试结束时关闭服务端
+func startUDPTestServer(t *testing.T, opts ...NormalServerOption) (*UDPServer, net.Addr) {
+	t.Helper()
+	server := NewUDPServer(opts...).(*UDPServer)
+	server.base.address = "127.0.0.1:0"
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.Run()
+	}()
+	deadline := time.Now().Add(3 * time.Second)
+	for !server.base.isRunning.Load() {
+		select {
+		case err := <-failed:
+			t.Fatalf("udp server run: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("udp server not running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return server, server.conn.LocalAddr()
+}
+
+// dialUDP 创建连接至UDP服务端的客户端
+func dialUDP(t *testing.T, addr net.Addr) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// udpNotifyHandler 回显处理器，会话关闭时发送通知，通知未被取走时不阻塞会话的关闭
+type udpNotifyHandler struct {
+	testEchoHandler
+	closed chan struct{}
+}
+
+func (h *udpNotifyHandler) OnClosedHandler(net.Conn) error {
+	select {
+	case h.closed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rejectFirstHandler 在连接建立事件中关闭第一个连接
+type rejectFirstHandler struct {
+	udpNotifyHandler
+	rejected atomic.Bool
+}
+
+func (h *rejectFirstHandler) OnConnectHandler(conn net.Conn) context.Context {
+	if h.rejected.CompareAndSwap(false, true) {
+		_ = conn.Close()
+	}
+	return context.Background()
+}
+
+func TestUDPServer(t *testing.T) {
+	t.Run("reply routing", func(t *testing.T) {
+		server, addr := startUDPTestServer(t, WithHandler(&testEchoHandler{}))
+		clients := []net.Conn{dialUDP(t, addr), dialUDP(t, addr)}
+		for round := 0; round < 2; round++ {
+			for i, conn := range clients {
+				request := NewMessage(1, []byte(fmt.Sprintf("client-%d", i)))
+				request.PutSeq(uint64(round + 1))
+				reply, err := testCall(conn, request)
+				if err != nil {
+					t.Fatalf("client %d: %v", i, err)
+				}
+				if want := fmt.Sprintf("echo:client-%d", i); string(reply.Payload()) != want || reply.Seq() != uint64(round+1) {
+					t.Fatalf("client %d got %q seq %d, want %q seq %d", i, reply.Payload(), reply.Seq(), want, round+1)
+				}
+			}
+		}
+		// 同一远端地址的数据报复用同一个会话
+		if count := server.GetSessionManager().Count(); count != len(clients) {
+			t.Fatalf("sessions = %d, want %d", count, len(clients))
+		}
+	})
+
+	t.Run("idle expiry", func(t *testing.T) {
+		handler := &udpNotifyHandler{closed: make(chan struct{}, 1)}
+		server, addr := startUDPTestServer(t, WithHandler(handler), WithIdleTimeout(100*time.Millisecond))
+		if _, err := testCall(dialUDP(t, addr), NewMessage(1, []byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-handler.closed:
+		case <-time.After(3 * time.Second):
+			t.Fatal("idle session not expired")
+		}
+		if count := server.GetSessionManager().Count(); count != 0 {
+			t.Fatalf("sessions = %d after expiry", count)
+		}
+		if len(server.snapshot()) != 0 {
+			t.Fatal("expired session still bound to its remote address")
+		}
+	})
+
+	t.Run("reject in OnConnectHandler", func(t *testing.T) {
+		handler := &rejectFirstHandler{udpNotifyHandler: udpNotifyHandler{closed: make(chan struct{}, 1)}}
+		server, addr := startUDPTestServer(t, WithHandler(handler))
+		rejected := dialUDP(t, addr)
+		pack, _ := NewNormalPacker().Pack(NewMessage(1, []byte("hello")))
+		if _, err := rejected.Write(pack); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-handler.closed:
+		case <-time.After(3 * time.Second):
+			t.Fatal("rejected session not closed")
+		}
+		// 拒绝远端地址后接收协程仍在运行，其他远端地址以及被拒绝的地址重新发送时均可正常建立会话
+		for _, conn := range []net.Conn{dialUDP(t, addr), rejected} {
+			reply, err := testCall(conn, NewMessage(1, []byte("hello")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(reply.Payload()) != "echo:hello" {
+				t.Fatalf("got %q", reply.Payload())
+			}
+		}
+		if count := server.GetSessionManager().Count(); count != 2 {
+			t.Fatalf("sessions = %d, want 2", count)
+		}
+	})
+}
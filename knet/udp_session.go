@@ -0,0 +1,322 @@
+// @Title udp_session.go
+// @Description UDP 伪会话，将同一远端地址的数据报视为一个会话
+// @Author Zero - 2023/10/13 15:02:26
+
+package knet
+
+import (
+	"context"
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDPSession UDP 伪会话，搭配 UDPServer 使用
+// 服务端读取的数据报解包后投递至会话的收件箱，由会话的读协程按序处理;
+// 会话的写操作直接将数据包作为一个数据报发送至远端地址.
+type UDPSession struct {
+	// 会话ID
+	ID uint32
+	// 远端地址
+	remote *net.UDPAddr
+	// 伪连接，用于 IHandler 的连接事件回调
+	conn *udpConn
+	// 会话所属的服务端
+	server *UDPServer
+	// 会话上下文
+	context context.Context
+	// 会话上下文取消方法
+	cancel context.CancelFunc
+	// 认证主体
+	principal any
+	// 会话是否关闭
+	closed atomic.Bool
+	// 保证会话只关闭一次
+	closeOnce sync.Once
+	// 最近一次收到数据报的时间(UnixNano)
+	lastActive atomic.Int64
+	// 时间轮上的空闲过期检测任务
+	expiryTimer atomic.Pointer[WheelTimer]
+	// 收件箱，存放已解包的消息
+	inbox chan kiface.IMessage
+	// 优雅关闭信号，读协程处理完收件箱中剩余的消息后退出
+	flushing chan struct{}
+	// 读协程退出信号
+	readerDone chan struct{}
+	// 会话加入的分组名称
+	groups map[string]struct{}
+	// groups 的互斥锁
+	groupsLock sync.Mutex
+}
+
+// newUDPSession 创建UDP伪会话
+func newUDPSession(server *UDPServer, id uint32, remote *net.UDPAddr) *UDPSession {
+	session := &UDPSession{
+		ID:         id,
+		remote:     remote,
+		server:     server,
+		inbox:      make(chan kiface.IMessage, udpInboxSize),
+		flushing:   make(chan struct{}),
+		readerDone: make(chan struct{}),
+		groups:     make(map[string]struct{}),
+	}
+	session.conn = &udpConn{session: session}
+	session.lastActive.Store(time.Now().UnixNano())
+	return session
+}
+
+// Reader 会话的读任务，依次处理收件箱中的消息
+func (us *UDPSession) Reader() {
+	defer close(us.readerDone)
+	for {
+		select {
+		case message := <-us.inbox:
+			if !us.handle(message) {
+				return
+			}
+		case <-us.flushing:
+			// 服务端优雅关闭，处理完收件箱中剩余的消息后退出
+			for {
+				select {
+				case message := <-us.inbox:
+					if !us.handle(message) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-us.context.Done():
+			return
+		}
+	}
+}
+
+// handle 处理一条消息，处理函数返回错误时关闭会话并返回false
+func (us *UDPSession) handle(message kiface.IMessage) bool {
+	// 心跳消息由会话直接处理
+	if handleHeartbeat(us, message) {
+		return true
+	}
+	if err := us.server.base.dispatch(us, message); err != nil {
+		us.Stop()
+		return false
+	}
+	return true
+}
+
+// shutdown 优雅关闭会话: 发送告别消息，处理完收件箱中剩余的消息后关闭会话
+// UDP 会话没有写队列，消息在处理时已同步发送；ctx 到期时直接返回，由调用方强制关闭会话
+func (us *UDPSession) shutdown(ctx context.Context, goodbye kiface.IMessage) {
+	if us.IsClose() {
+		return
+	}
+	if goodbye != nil {
+		_ = us.Write(goodbye)
+	}
+	close(us.flushing)
+	select {
+	case <-us.readerDone:
+	case <-ctx.Done():
+		return
+	}
+	us.Stop()
+}
+
+// deliver 将消息投递至收件箱，收件箱已满时丢弃该消息
+func (us *UDPSession) deliver(message kiface.IMessage) {
+	us.lastActive.Store(time.Now().UnixNano())
+	select {
+	case us.inbox <- message:
+	default:
+	}
+}
+
+// Read 从收件箱中读取一条消息
+func (us *UDPSession) Read(timeout time.Duration) (kiface.IMessage, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case message := <-us.inbox:
+		return message, nil
+	case <-timer.C:
+		return nil, errUDPReadTimeout
+	case <-us.context.Done():
+		return nil, ErrSessionClosed
+	}
+}
+
+// Write 将消息封包后作为一个数据报发送
+func (us *UDPSession) Write(message kiface.IMessage) error {
+	if us.IsClose() {
+		return ErrSessionClosed
+	}
+	pack, err := us.server.base.packer.Pack(message)
+	if err != nil {
+		return err
+	}
+	_, err = us.server.conn.WriteToUDP(pack, us.remote)
+	ReleasePack(us.server.base.packer, pack)
+	return err
+}
+
+// Send 发送消息，UDP 数据报的发送不会阻塞，因此与 Write 相同
+func (us *UDPSession) Send(message kiface.IMessage) error {
+	return us.Write(message)
+}
+
+// TrySend 发送消息，发送失败时返回false
+func (us *UDPSession) TrySend(message kiface.IMessage) bool {
+	return us.Write(message) == nil
+}
+
+// Join 加入指定名称的分组
+func (us *UDPSession) Join(group string) error {
+	us.groupsLock.Lock()
+	if us.IsClose() {
+		us.groupsLock.Unlock()
+		return ErrSessionClosed
+	}
+	us.groups[group] = struct{}{}
+	us.groupsLock.Unlock()
+	us.server.base.groups.Join(group, us)
+	if us.IsClose() {
+		us.server.base.groups.Leave(group, us)
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// Leave 离开指定名称的分组
+func (us *UDPSession) Leave(group string) {
+	us.groupsLock.Lock()
+	_, ok := us.groups[group]
+	delete(us.groups, group)
+	us.groupsLock.Unlock()
+	if ok {
+		us.server.base.groups.Leave(group, us)
+	}
+}
+
+// leaveAll 离开所有分组
+func (us *UDPSession) leaveAll() {
+	us.groupsLock.Lock()
+	groups := us.groups
+	us.groups = make(map[string]struct{})
+	us.groupsLock.Unlock()
+	for group := range groups {
+		us.server.base.groups.Leave(group, us)
+	}
+}
+
+// GetSessionID 获取会话的ID
+func (us *UDPSession) GetSessionID() uint32 {
+	return us.ID
+}
+
+// GetRemoteAddr 获取远端地址
+func (us *UDPSession) GetRemoteAddr() net.Addr {
+	return us.remote
+}
+
+// GetContext 获取会话的上下文
+func (us *UDPSession) GetContext() context.Context {
+	return us.context
+}
+
+// GetPrincipal 获取会话的认证主体
+func (us *UDPSession) GetPrincipal() any {
+	return us.principal
+}
+
+// Stop 关闭会话
+func (us *UDPSession) Stop() {
+	us.closeOnce.Do(func() {
+		us.closed.Store(true)
+		// 在连接建立事件中关闭时会话上下文尚未创建
+		if us.cancel != nil {
+			us.cancel()
+		}
+		if timer := us.expiryTimer.Load(); timer != nil {
+			timer.Stop()
+		}
+		if handler := us.server.base.handler; handler != nil {
+			_ = handler.OnClosedHandler(us.conn)
+		}
+		us.leaveAll()
+		us.server.removeSession(us)
+	})
+}
+
+// IsClose 会话是否已关闭
+func (us *UDPSession) IsClose() bool {
+	return us.closed.Load()
+}
+
+// scheduleExpiry 在服务端的时间轮上注册空闲过期检测，到期时间为最近一次收到数据报的时间加上空闲过期时间
+func (us *UDPSession) scheduleExpiry() {
+	deadline := time.Unix(0, us.lastActive.Load()).Add(us.server.idleTimeout)
+	us.expiryTimer.Store(us.server.base.wheel.AfterFunc(time.Until(deadline), us.checkExpiry))
+}
+
+// checkExpiry 时间轮到期时执行，会话已空闲过期则关闭，否则按最近的活跃时间重新注册
+func (us *UDPSession) checkExpiry() {
+	if us.IsClose() {
+		return
+	}
+	if us.idle(time.Now(), us.server.idleTimeout) {
+		us.Stop()
+		return
+	}
+	us.scheduleExpiry()
+}
+
+// idle 会话是否已空闲超过指定时间
+func (us *UDPSession) idle(now time.Time, timeout time.Duration) bool {
+	return now.Sub(time.Unix(0, us.lastActive.Load())) > timeout
+}
+
+// udpConn UDP 伪会话的连接视图，实现 net.Conn 以复用 IHandler 的连接事件回调
+// 消息已由服务端统一读取并投递给会话，因此 Read 不可用；Write 直接发送原始数据报.
+type udpConn struct {
+	session *UDPSession
+}
+
+func (c *udpConn) Read([]byte) (int, error) {
+	return 0, errUDPConnRead
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	return c.session.server.conn.WriteToUDP(b, c.session.remote)
+}
+
+func (c *udpConn) Close() error {
+	// 会话关闭过程中(如在 OnClosedHandler 内)调用时直接返回，避免重复进入 Stop 造成死锁
+	if c.session.IsClose() {
+		return nil
+	}
+	c.session.Stop()
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.session.server.conn.LocalAddr()
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.session.remote
+}
+
+func (c *udpConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *udpConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *udpConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
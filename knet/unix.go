@@ -0,0 +1,136 @@
+// @Title unix.go
+// @Description Unix 域套接字监听以及对端进程凭证
+// @Author Zero - 2023/10/14 10:26:37
+
+package knet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// PeerCred Unix 域套接字对端进程的凭证，连接建立时由内核记录
+type PeerCred struct {
+	// 对端进程ID
+	PID int32
+	// 对端进程的用户ID
+	UID uint32
+	// 对端进程的用户组ID
+	GID uint32
+}
+
+// PeerCredentials 获取 Unix 域套接字连接对端进程的凭证，非 Unix 域套接字连接或当前平台不支持时返回false
+// 可在 IHandler.OnConnectHandler 中根据对端进程的用户进行鉴权
+func PeerCredentials(conn net.Conn) (PeerCred, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.UnixConn:
+			cred, err := peerCred(c)
+			if err != nil {
+				return PeerCred{}, false
+			}
+			return cred, true
+		case netConner:
+			conn = c.NetConn()
+		default:
+			return PeerCred{}, false
+		}
+	}
+}
+
+// listenUnix 监听 Unix 域套接字，监听前清理残留的套接字文件
+// mode 不为0时修改套接字文件的权限，监听器关闭时自动删除套接字文件.
+// 修改权限时，套接字先在同目录下仅当前用户可访问(0700)的临时目录中创建并修改权限，再移动至目标路径，
+// 避免套接字在修改权限之前以默认权限暴露给其他用户.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		listener.SetUnlinkOnClose(true)
+		return listener, nil
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".kinx")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, mode); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = listener.Close()
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// unixListener 套接字文件创建后被移动过的监听器，以移动后的路径作为监听地址，关闭时删除该套接字文件
+type unixListener struct {
+	*net.UnixListener
+	// 套接字文件路径
+	path string
+}
+
+// Addr 获取监听地址
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close 关闭监听器并删除套接字文件
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if removeErr := os.Remove(l.path); err == nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+	return err
+}
+
+// removeStaleSocket 删除上次运行残留的套接字文件
+// 文件不是套接字，或者仍有服务在该套接字上监听时返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("knet: %s already exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("knet: socket %s is already in use", path)
+	}
+	fmt.Printf("remove stale socket file: %s \n", path)
+	return os.Remove(path)
+}
+
+// parseSocketMode 解析八进制的套接字文件权限，如 "0660"，为空时返回0
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if len(mode) == 0 {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("knet: invalid socket mode: %s", mode)
+	}
+	return os.FileMode(perm), nil
+}
@@ -0,0 +1,32 @@
+// @Title unix_cred_linux.go
+// @Description 通过 SO_PEERCRED 获取 Unix 域套接字对端进程的凭证
+// @Author Zero - 2023/10/14 11:03:15
+
+//go:build linux
+
+package knet
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred 获取 Unix 域套接字对端进程的凭证
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCred{}, err
+	}
+	if credErr != nil {
+		return PeerCred{}, credErr
+	}
+	return PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
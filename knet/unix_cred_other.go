@@ -0,0 +1,17 @@
+// @Title unix_cred_other.go
+// @Description 不支持 SO_PEERCRED 的平台
+// @Author Zero - 2023/10/14 11:05:48
+
+//go:build !linux
+
+package knet
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCred 当前平台不支持获取对端进程的凭证
+func peerCred(*net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, errors.New("knet: peer credentials are not supported on this platform")
+}
@@ -0,0 +1,117 @@
+// @Title unix_test.go
+// @Description Unix 域套接字的文件权限、残留套接字清理以及对端进程凭证的测试
+// @Author Zero - 2023/10/22 19:52:06
+
+//go:build linux
+
+package knet
+
+import (
+	"github.com/zlx2019/kinx/kiface"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenUnixMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kinx.sock")
+	listener, err := listenUnix(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode = %s, want socket 0600", info.Mode())
+	}
+	if addr := listener.Addr().String(); addr != path {
+		t.Fatalf("listener addr = %s, want %s", addr, path)
+	}
+	// 创建套接字使用的临时目录已被删除
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("%d entries left in socket dir, want 1", len(entries))
+	}
+	// 移动后的套接字仍可正常连接
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if err = listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed on close: %v", err)
+	}
+}
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kinx.sock")
+	// 残留的套接字文件: 监听器关闭时未删除套接字文件
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	if _, err = os.Lstat(path); err != nil {
+		t.Fatal("stale socket file not left behind")
+	}
+	listener, err := listenUnix(path, 0)
+	if err != nil {
+		t.Fatalf("listen over stale socket: %v", err)
+	}
+	defer listener.Close()
+
+	// 仍有服务在监听的套接字不会被删除
+	if _, err = listenUnix(path, 0); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("listen over live socket got %v, want in use error", err)
+	}
+	// 非套接字文件不会被删除
+	regular := filepath.Join(dir, "regular")
+	if err = os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = listenUnix(regular, 0); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("listen over regular file got %v, want not a socket error", err)
+	}
+}
+
+func TestPeerCred(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kinx.sock")
+	server, _ := startTestServer(t, ListenerConfig{Network: "unix", Address: path, SocketMode: 0o600}, WithHandler(&testEchoHandler{}))
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = testCall(conn, NewMessage(1, []byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for server.GetSessionManager().Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checked := false
+	server.GetSessionManager().Range(func(session kiface.ISession) bool {
+		cred, ok := session.(*NormalSession).GetPeerCred()
+		if !ok {
+			t.Fatal("peer credentials not available")
+		}
+		if cred.PID != int32(os.Getpid()) || cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) {
+			t.Fatalf("peer cred = %+v, want pid %d uid %d gid %d", cred, os.Getpid(), os.Getuid(), os.Getgid())
+		}
+		checked = true
+		return true
+	})
+	if !checked {
+		t.Fatal("no session to check")
+	}
+}
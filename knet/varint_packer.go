@@ -0,0 +1,220 @@
+// @Title varint_packer.go
+// @Description 紧凑的变长头部消息处理器
+// @Author Zero - 2023/10/7 20:14:33
+
+package knet
+
+import (
+	"encoding/binary"
+	"github.com/zlx2019/kinx/kiface"
+	"io"
+	"math"
+)
+
+// FieldWidth 头部字段的编码宽度
+type FieldWidth int
+
+const (
+	// WidthUvarint 变长编码，占用 1~10 byte
+	WidthUvarint FieldWidth = iota
+	// WidthFixed16 固定 2 byte
+	WidthFixed16
+	// WidthFixed32 固定 4 byte
+	WidthFixed32
+)
+
+// 头部的最大字节数: 长度、ID、序列号均为 uvarint 时各最多 10 byte
+const maxVarintHeaderSize = binary.MaxVarintLen64 * 3
+
+// VarintPacker 紧凑的消息处理器，用于大量小消息的场景(如遥测数据)，头部最少仅需 2 byte
+// 数据包格式: [Len|ID|Payload]，携带序列号时为 [Len|ID|Seq|Payload]
+// Len 字段的值为 内容长度<<1 | 是否携带序列号，Seq 字段固定使用 uvarint 编码;
+// Len 与 ID 字段可分别选择 uvarint 或 2/4 byte 的定长编码，定长编码使用指定的字节序.
+// 不携带消息标志位以及元数据，因此不能被 CompressPacker 包装.
+type VarintPacker struct {
+	// 定长字段的字节序
+	byteOrder binary.ByteOrder
+	// 长度字段的编码宽度
+	lenWidth FieldWidth
+	// ID字段的编码宽度
+	idWidth FieldWidth
+	// 允许的最大消息内容长度，0 表示不限制
+	maxPayloadSize uint64
+}
+
+// VarintPackerOption VarintPacker的配置注册函数
+type VarintPackerOption func(packer *VarintPacker)
+
+// WithByteOrder 设置定长字段的字节序，默认为大端序
+func WithByteOrder(order binary.ByteOrder) VarintPackerOption {
+	return func(p *VarintPacker) {
+		p.byteOrder = order
+	}
+}
+
+// WithLenWidth 设置长度字段的编码宽度，默认为 WidthUvarint
+func WithLenWidth(width FieldWidth) VarintPackerOption {
+	return func(p *VarintPacker) {
+		p.lenWidth = width
+	}
+}
+
+// WithIDWidth 设置ID字段的编码宽度，默认为 WidthUvarint
+func WithIDWidth(width FieldWidth) VarintPackerOption {
+	return func(p *VarintPacker) {
+		p.idWidth = width
+	}
+}
+
+// NewVarintPacker 构造函数
+func NewVarintPacker(opts ...VarintPackerOption) kiface.IPacker {
+	packer := &VarintPacker{
+		byteOrder:      binary.BigEndian,
+		lenWidth:       WidthUvarint,
+		idWidth:        WidthUvarint,
+		maxPayloadSize: DefaultMaxPayloadSize,
+	}
+	for _, opt := range opts {
+		opt(packer)
+	}
+	return packer
+}
+
+// SetMaxPayloadSize 设置允许的最大消息内容长度，0 表示不限制
+func (packer *VarintPacker) SetMaxPayloadSize(size uint64) {
+	packer.maxPayloadSize = size
+}
+
+// pooledPack 数据包缓冲区取自缓冲池
+func (packer *VarintPacker) pooledPack() bool {
+	return true
+}
+
+// Pack 消息打包
+func (packer *VarintPacker) Pack(message kiface.IMessage) ([]byte, error) {
+	var header [maxVarintHeaderSize]byte
+	lens := message.Len() << 1
+	if message.Seq() != 0 {
+		lens |= 1
+	}
+	// 写入长度字段
+	n, err := packer.putField(header[:], packer.lenWidth, lens)
+	if err != nil {
+		return nil, err
+	}
+	// 写入ID字段
+	m, err := packer.putField(header[n:], packer.idWidth, message.ID())
+	if err != nil {
+		return nil, err
+	}
+	n += m
+	// 写入序列号字段
+	if message.Seq() != 0 {
+		n += binary.PutUvarint(header[n:], message.Seq())
+	}
+	packs := GetBuffer(n + len(message.Payload()))
+	copy(packs, header[:n])
+	copy(packs[n:], message.Payload())
+	return packs, nil
+}
+
+// UnPack 消息解包
+// 解析 uvarint 字段需要逐字节读取，reader 最好为带缓冲的 io.ByteReader(如 bufio.Reader)
+func (packer *VarintPacker) UnPack(reader io.Reader) (kiface.IMessage, error) {
+	r := toByteReader(reader)
+	// 读取长度字段
+	lens, err := packer.readField(r, packer.lenWidth)
+	if err != nil {
+		return nil, err
+	}
+	// 读取ID字段
+	id, err := packer.readField(r, packer.idWidth)
+	if err != nil {
+		return nil, err
+	}
+	// 读取序列号字段
+	var seq uint64
+	if lens&1 != 0 {
+		if seq, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+	}
+	// 在分配内存前校验消息内容长度
+	if err = checkPayloadSize(lens>>1, packer.maxPayloadSize); err != nil {
+		return nil, err
+	}
+	// 读取消息内容
+	payloadBuf := getBuffer(int(lens >> 1))
+	if _, err = io.ReadFull(r, *payloadBuf); err != nil {
+		putBuffer(payloadBuf)
+		return nil, err
+	}
+	message := newPooledMessage(id, *payloadBuf, payloadBuf)
+	message.PutSeq(seq)
+	return message, nil
+}
+
+// putField 按照编码宽度写入字段，返回写入的字节数；超过定长字段的表示范围时返回 ErrFieldOverflow
+func (packer *VarintPacker) putField(buf []byte, width FieldWidth, value uint64) (int, error) {
+	switch width {
+	case WidthFixed16:
+		if value > math.MaxUint16 {
+			return 0, ErrFieldOverflow
+		}
+		packer.byteOrder.PutUint16(buf, uint16(value))
+		return 2, nil
+	case WidthFixed32:
+		if value > math.MaxUint32 {
+			return 0, ErrFieldOverflow
+		}
+		packer.byteOrder.PutUint32(buf, uint32(value))
+		return 4, nil
+	default:
+		return binary.PutUvarint(buf, value), nil
+	}
+}
+
+// readField 按照编码宽度读取字段
+func (packer *VarintPacker) readField(r *byteReader, width FieldWidth) (uint64, error) {
+	switch width {
+	case WidthFixed16:
+		if _, err := io.ReadFull(r, r.scratch[:2]); err != nil {
+			return 0, err
+		}
+		return uint64(packer.byteOrder.Uint16(r.scratch[:2])), nil
+	case WidthFixed32:
+		if _, err := io.ReadFull(r, r.scratch[:4]); err != nil {
+			return 0, err
+		}
+		return uint64(packer.byteOrder.Uint32(r.scratch[:4])), nil
+	default:
+		return binary.ReadUvarint(r)
+	}
+}
+
+// byteReader 为 io.Reader 提供逐字节读取的能力
+type byteReader struct {
+	io.Reader
+	// 底层 reader 本身支持逐字节读取时直接使用
+	br io.ByteReader
+	// 读取缓冲区
+	scratch [4]byte
+}
+
+// toByteReader 包装 io.Reader
+func toByteReader(reader io.Reader) *byteReader {
+	r := &byteReader{Reader: reader}
+	r.br, _ = reader.(io.ByteReader)
+	return r
+}
+
+// ReadByte 读取一个字节
+func (r *byteReader) ReadByte() (byte, error) {
+	if r.br != nil {
+		return r.br.ReadByte()
+	}
+	if _, err := io.ReadFull(r.Reader, r.scratch[:1]); err != nil {
+		return 0, err
+	}
+	return r.scratch[0], nil
+}
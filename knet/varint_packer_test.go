@@ -0,0 +1,76 @@
+// @Title varint_packer_test.go
+// @Description VarintPacker 的编解码测试以及与 NormalPacker 的基准对比
+// @Author Zero - 2023/10/22 14:05:12
+
+package knet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"testing"
+)
+
+// benchmarkPayloadSizes 基准测试使用的消息内容长度
+var benchmarkPayloadSizes = []int{16, 256, 4096, 65536}
+
+// benchmarkPacker 对消息处理器的封包与解包进行基准测试
+func benchmarkPacker(b *testing.B, packer kiface.IPacker) {
+	for _, size := range benchmarkPayloadSizes {
+		message := NewMessage(1024, bytes.Repeat([]byte{'k'}, size))
+		b.Run(fmt.Sprintf("Pack/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if _, err := packer.Pack(message); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		pack, err := packer.Pack(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("UnPack/%dB", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			reader := bytes.NewReader(pack)
+			for i := 0; i < b.N; i++ {
+				reader.Reset(pack)
+				if _, err := packer.UnPack(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNormalPacker(b *testing.B) {
+	benchmarkPacker(b, NewNormalPacker())
+}
+
+func BenchmarkVarintPacker(b *testing.B) {
+	benchmarkPacker(b, NewVarintPacker())
+}
+
+func TestVarintPackerRoundTrip(t *testing.T) {
+	widths := []FieldWidth{WidthUvarint, WidthFixed16, WidthFixed32}
+	for _, lenWidth := range widths {
+		for _, idWidth := range widths {
+			packer := NewVarintPacker(WithLenWidth(lenWidth), WithIDWidth(idWidth))
+			message := NewMessage(300, []byte("telemetry"))
+			message.PutSeq(7)
+			pack, err := packer.Pack(message)
+			if err != nil {
+				t.Fatalf("len %d id %d: pack: %v", lenWidth, idWidth, err)
+			}
+			got, err := packer.UnPack(bytes.NewReader(pack))
+			if err != nil {
+				t.Fatalf("len %d id %d: unpack: %v", lenWidth, idWidth, err)
+			}
+			if got.ID() != 300 || got.Seq() != 7 || string(got.Payload()) != "telemetry" {
+				t.Fatalf("len %d id %d: got id=%d seq=%d payload=%q", lenWidth, idWidth, got.ID(), got.Seq(), got.Payload())
+			}
+		}
+	}
+}
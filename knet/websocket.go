@@ -0,0 +1,420 @@
+// @Title websocket.go
+// @Description WebSocket 传输适配，将 WebSocket 连接适配为 net.Conn，供会话以及消息处理器直接使用
+// @Author Zero - 2023/10/15 16:40:12
+
+package knet
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebSocket 帧的操作码
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket 关闭帧的状态码
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+)
+
+// 计算 Sec-WebSocket-Accept 使用的GUID
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// 控制帧的最大负载长度
+const wsMaxControlPayload = 125
+
+// wsListener WebSocket 监听器，将接收到的连接包装为 WebSocket 连接
+// 升级握手在会话建立时完成(与TLS握手相同)，不会阻塞接收新的连接.
+type wsListener struct {
+	net.Listener
+	// 允许升级的请求路径，为空时不限制
+	path string
+}
+
+// newWebSocketListener 创建 WebSocket 监听器
+func newWebSocketListener(listener net.Listener, path string) net.Listener {
+	return &wsListener{Listener: listener, path: path}
+}
+
+// Accept 接收连接，并且包装为服务端的 WebSocket 连接
+func (l *wsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newWebSocketConn(conn, true, l.path, ""), nil
+}
+
+// NewWebSocketClient 在已建立的连接上完成客户端的 WebSocket 升级握手，返回客户端的 WebSocket 连接
+// 每次 Write 发送一个二进制帧，Read 按字节流读取二进制帧的负载，可直接搭配 IPacker 使用.
+// @param	host	请求头中的 Host
+// @param	path	请求路径，需与服务端 WithWebSocket 设置的路径一致
+func NewWebSocketClient(conn net.Conn, host, path string, timeout time.Duration) (net.Conn, error) {
+	ws := newWebSocketConn(conn, false, path, host)
+	if err := handshake(ws, timeout); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// WebSocketRequest 获取 WebSocket 连接的升级请求，非 WebSocket 连接返回false
+// 可在 IHandler.OnConnectHandler 中根据请求的 Origin、Cookie 等请求头进行鉴权
+func WebSocketRequest(conn net.Conn) (*http.Request, bool) {
+	for {
+		switch c := conn.(type) {
+		case *wsConn:
+			if c.request == nil {
+				return nil, false
+			}
+			return c.request, true
+		case netConner:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// wsConn WebSocket 连接，实现 net.Conn
+// 读取时将连续的二进制帧(包括分片)的负载视为字节流，自动应答 Ping 与 Close 控制帧；
+// 写入时每次 Write 发送一个二进制帧，因此会话每条消息恰好对应一个帧.
+type wsConn struct {
+	// 底层连接
+	net.Conn
+	// 底层连接的读缓冲区，用于在读取超时的情况下完整地解析帧头
+	reader *bufio.Reader
+	// 是否为服务端连接，服务端要求客户端的帧必须掩码，且发送的帧不掩码
+	isServer bool
+	// 服务端: 允许升级的请求路径；客户端: 请求路径
+	path string
+	// 客户端请求头中的 Host
+	host string
+	// 服务端接收到的升级请求
+	request *http.Request
+	// 保证只握手一次
+	handshakeOnce sync.Once
+	// 握手的结果
+	handshakeErr error
+	// 是否已完成升级握手
+	upgraded atomic.Bool
+	// 当前数据帧剩余未读取的负载长度
+	remaining uint64
+	// 当前数据帧的掩码
+	mask [4]byte
+	// 当前数据帧是否掩码
+	masked bool
+	// 当前数据帧已读取的负载长度，用于计算掩码的位置
+	maskPos int
+	// 写锁，写协程与读协程(应答控制帧)可能同时写入
+	writeLock sync.Mutex
+	// 保证只关闭一次
+	closeOnce sync.Once
+}
+
+// newWebSocketConn 包装 WebSocket 连接
+func newWebSocketConn(conn net.Conn, isServer bool, path, host string) *wsConn {
+	return &wsConn{
+		Conn:     conn,
+		reader:   bufio.NewReader(conn),
+		isServer: isServer,
+		path:     path,
+		host:     host,
+	}
+}
+
+// NetConn 获取底层连接
+func (w *wsConn) NetConn() net.Conn {
+	return w.Conn
+}
+
+// HandshakeContext 完成 WebSocket 升级握手，只执行一次；ctx 的截止时间作为握手的超时时间
+func (w *wsConn) HandshakeContext(ctx context.Context) error {
+	w.handshakeOnce.Do(func() {
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = w.Conn.SetDeadline(deadline)
+			defer w.Conn.SetDeadline(time.Time{})
+		}
+		if w.isServer {
+			w.handshakeErr = w.serverHandshake()
+		} else {
+			w.handshakeErr = w.clientHandshake()
+		}
+		w.upgraded.Store(w.handshakeErr == nil)
+	})
+	return w.handshakeErr
+}
+
+// serverHandshake 读取客户端的升级请求，校验通过后响应 101
+func (w *wsConn) serverHandshake() error {
+	request, err := http.ReadRequest(w.reader)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWebSocketHandshake, err.Error())
+	}
+	if status, reason := w.checkUpgrade(request); status != 0 {
+		_, _ = fmt.Fprintf(w.Conn, "HTTP/1.1 %d %s\r\nSec-WebSocket-Version: 13\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+		return fmt.Errorf("%w: %s", ErrWebSocketHandshake, reason)
+	}
+	w.request = request
+	_, err = fmt.Fprintf(w.Conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		wsAcceptKey(request.Header.Get("Sec-WebSocket-Key")))
+	return err
+}
+
+// checkUpgrade 校验升级请求，校验失败时返回响应的状态码以及原因
+func (w *wsConn) checkUpgrade(request *http.Request) (int, string) {
+	if request.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, "method " + request.Method
+	}
+	if len(w.path) > 0 && request.URL.Path != w.path {
+		return http.StatusNotFound, "path " + request.URL.Path
+	}
+	if !headerContains(request.Header, "Connection", "upgrade") || !headerContains(request.Header, "Upgrade", "websocket") {
+		return http.StatusBadRequest, "not a websocket upgrade request"
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, "unsupported version " + request.Header.Get("Sec-WebSocket-Version")
+	}
+	key, err := base64.StdEncoding.DecodeString(request.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return http.StatusBadRequest, "invalid Sec-WebSocket-Key"
+	}
+	return 0, ""
+}
+
+// clientHandshake 发送升级请求，并且校验服务端的响应
+func (w *wsConn) clientHandshake() error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	_, err := fmt.Fprintf(w.Conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		w.path, w.host, key)
+	if err != nil {
+		return err
+	}
+	response, err := http.ReadResponse(w.reader, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWebSocketHandshake, err.Error())
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%w: unexpected status %s", ErrWebSocketHandshake, response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrWebSocketHandshake)
+	}
+	return nil
+}
+
+// Read 读取二进制帧的负载
+func (w *wsConn) Read(p []byte) (int, error) {
+	if err := w.HandshakeContext(context.Background()); err != nil {
+		return 0, err
+	}
+	for w.remaining == 0 {
+		if err := w.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+	n, err := w.reader.Read(p)
+	if w.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= w.mask[(w.maskPos+i)&3]
+		}
+		w.maskPos += n
+	}
+	w.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame 读取下一个帧头，数据帧记录其负载长度，控制帧直接处理
+// 帧头完整到达前不会消费缓冲区的数据，读取超时后可以安全地重试.
+func (w *wsConn) nextFrame() error {
+	header, err := w.reader.Peek(2)
+	if err != nil {
+		return err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	size := 2
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if masked {
+		size += 4
+	}
+	if header[0]&0x70 != 0 || masked != w.isServer {
+		// 未协商扩展却设置了 RSV 位，或者掩码方向错误
+		return w.fail(wsCloseProtocolError)
+	}
+	if header, err = w.reader.Peek(size); err != nil {
+		return err
+	}
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(header[2:]))
+	case 127:
+		length = binary.BigEndian.Uint64(header[2:])
+	}
+	var mask [4]byte
+	if masked {
+		copy(mask[:], header[size-4:])
+	}
+	switch opcode {
+	case wsOpBinary, wsOpContinuation:
+		_, _ = w.reader.Discard(size)
+		w.remaining, w.mask, w.masked, w.maskPos = length, mask, masked, 0
+		return nil
+	case wsOpPing, wsOpPong, wsOpClose:
+		if !fin || length > wsMaxControlPayload {
+			return w.fail(wsCloseProtocolError)
+		}
+		frame, err := w.reader.Peek(size + int(length))
+		if err != nil {
+			return err
+		}
+		payload := make([]byte, length)
+		copy(payload, frame[size:])
+		_, _ = w.reader.Discard(size + int(length))
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+		}
+		switch opcode {
+		case wsOpPing:
+			return w.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			// 对端关闭连接，回应关闭帧
+			if len(payload) >= 2 {
+				payload = payload[:2]
+			}
+			_ = w.writeFrame(wsOpClose, payload)
+			return io.EOF
+		}
+		return nil
+	case wsOpText:
+		// 仅支持二进制帧
+		return w.fail(wsCloseUnsupportedData)
+	default:
+		return w.fail(wsCloseProtocolError)
+	}
+}
+
+// fail 发送关闭帧通知对端错误原因，返回协议错误
+func (w *wsConn) fail(code uint16) error {
+	_ = w.writeClose(code)
+	return ErrWebSocketProtocol
+}
+
+// Write 将数据作为一个二进制帧发送
+func (w *wsConn) Write(p []byte) (int, error) {
+	if err := w.HandshakeContext(context.Background()); err != nil {
+		return 0, err
+	}
+	if err := w.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame 发送一个完整的帧，客户端发送的帧需要掩码
+func (w *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | opcode
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if !w.isServer {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i&3]
+		}
+		payload = masked
+	}
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(w.Conn)
+	return err
+}
+
+// writeClose 发送关闭帧
+func (w *wsConn) writeClose(code uint16) error {
+	return w.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+// Close 发送关闭帧后关闭底层连接
+func (w *wsConn) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		if w.upgraded.Load() {
+			_ = w.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_ = w.writeClose(wsCloseNormal)
+		}
+		err = w.Conn.Close()
+	})
+	return err
+}
+
+// wsAcceptKey 根据客户端的 Sec-WebSocket-Key 计算 Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains 请求头中是否包含指定的标记，不区分大小写
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// @Title websocket_test.go
+// @Description 使用进程内的 WebSocket 客户端测试 WebSocket 监听器
+// @Author Zero - 2023/10/22 16:10:47
+
+package knet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeNotifyHandler 回显处理器，会话关闭时发送通知
+type closeNotifyHandler struct {
+	testEchoHandler
+	closed chan struct{}
+}
+
+func (h *closeNotifyHandler) OnClosedHandler(net.Conn) error {
+	h.closed <- struct{}{}
+	return nil
+}
+
+// readTestFrame 读取一个未掩码的帧，仅用于读取服务端发送的控制帧
+func readTestFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("read frame header: %v", err)
+	}
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("read frame payload: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func TestWebSocket(t *testing.T) {
+	handler := &closeNotifyHandler{closed: make(chan struct{}, 1)}
+	_, addr := startTestServer(t, ListenerConfig{Name: "ws", WebSocket: true, WebSocketPath: "/ws"}, WithHandler(handler))
+	dial := func(path string) (*wsConn, error) {
+		raw, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = raw.Close() })
+		_ = raw.SetDeadline(time.Now().Add(3 * time.Second))
+		conn, err := NewWebSocketClient(raw, addr.String(), path, 3*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*wsConn), nil
+	}
+
+	conn, err := dial("/ws")
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	t.Run("request response", func(t *testing.T) {
+		request := NewMessage(7, []byte("hello"))
+		request.PutSeq(42)
+		reply, err := testCall(conn, request)
+		if err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		if reply.ID() != 7 || reply.Seq() != 42 || string(reply.Payload()) != "echo:hello" {
+			t.Fatalf("reply id=%d seq=%d payload=%q", reply.ID(), reply.Seq(), reply.Payload())
+		}
+	})
+
+	t.Run("ping pong", func(t *testing.T) {
+		if err := conn.writeFrame(wsOpPing, []byte("beat")); err != nil {
+			t.Fatalf("write ping: %v", err)
+		}
+		opcode, payload := readTestFrame(t, conn.reader)
+		if opcode != wsOpPong || string(payload) != "beat" {
+			t.Fatalf("got opcode %#x payload %q, want pong %q", opcode, payload, "beat")
+		}
+	})
+
+	t.Run("close frame", func(t *testing.T) {
+		if err := conn.writeClose(wsCloseNormal); err != nil {
+			t.Fatalf("write close: %v", err)
+		}
+		opcode, payload := readTestFrame(t, conn.reader)
+		if opcode != wsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+			t.Fatalf("got opcode %#x payload %v, want close %d", opcode, payload, wsCloseNormal)
+		}
+		select {
+		case <-handler.closed:
+		case <-time.After(3 * time.Second):
+			t.Fatal("session not closed after close frame")
+		}
+	})
+
+	t.Run("wrong path", func(t *testing.T) {
+		_, err := dial("/other")
+		if !errors.Is(err, ErrWebSocketHandshake) || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("got %v, want 404 handshake error", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+// @Title write_queue.go
+// @Description 会话发送队列的容量、溢出策略以及队列指标
+// @Author Zero - 2023/10/21 10:26:43
+
+package knet
+
+import (
+	"fmt"
+	"github.com/zlx2019/kinx/kiface"
+	"time"
+)
+
+const (
+	// defaultWriteQueueSize 默认的会话发送队列容量
+	defaultWriteQueueSize = 16
+	// defaultSendTimeout 默认的发送超时时间，用于 OverflowBlockTimeout 策略
+	defaultSendTimeout = 5 * time.Second
+)
+
+// OverflowPolicy 会话发送队列已满时的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞等待队列有空位，直到会话关闭，默认策略
+	OverflowBlock OverflowPolicy = iota
+	// OverflowBlockTimeout 阻塞等待队列有空位，超过发送超时时间(见 WithSendTimeout)返回 ErrSendTimeout
+	OverflowBlockTimeout
+	// OverflowDropNewest 丢弃本次发送的消息，返回 ErrQueueFull
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最早的消息，为本次发送的消息腾出空位
+	OverflowDropOldest
+	// OverflowCloseSlow 关闭消费过慢的会话，返回 ErrSlowConsumer
+	OverflowCloseSlow
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowBlockTimeout:
+		return "block-timeout"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowCloseSlow:
+		return "close-slow"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// QueueStats 会话发送队列的指标
+type QueueStats struct {
+	// 队列容量
+	Capacity int
+	// 当前队列中等待写出的消息数量
+	Depth int
+	// 队列深度的历史最大值
+	HighWater int
+	// 成功加入队列的消息数量
+	Enqueued uint64
+	// 因队列已满或发送超时而丢弃的消息数量
+	Dropped uint64
+	// 写协程写入连接的次数，一次写入可包含多个消息，Enqueued / Flushes 为平均批量大小
+	Flushes uint64
+}
+
+// QueueStats 获取会话发送队列的指标
+func (ns *NormalSession) QueueStats() QueueStats {
+	return QueueStats{
+		Capacity:  cap(ns.outChannel),
+		Depth:     len(ns.outChannel),
+		HighWater: int(ns.highWater.Load()),
+		Enqueued:  ns.enqueued.Load(),
+		Dropped:   ns.dropped.Load(),
+		Flushes:   ns.flushes.Load(),
+	}
+}
+
+// enqueue 将消息加入发送队列，队列已满时按服务端配置的溢出策略处理
+// block 为false时，阻塞类策略在队列已满时直接返回 ErrQueueFull
+func (ns *NormalSession) enqueue(message kiface.IMessage, block bool) error {
+	if ns.IsClose() {
+		return ErrSessionClosed
+	}
+	select {
+	case ns.outChannel <- message:
+		ns.markEnqueued()
+		return nil
+	default:
+	}
+	// 队列已满
+	switch ns.server.overflowPolicy {
+	case OverflowDropNewest:
+		ns.dropped.Add(1)
+		return ErrQueueFull
+	case OverflowDropOldest:
+		for !ns.IsClose() {
+			select {
+			case ns.outChannel <- message:
+				ns.markEnqueued()
+				return nil
+			default:
+			}
+			// 丢弃队列中最早的消息后重试，写协程可能同时取走消息
+			select {
+			case <-ns.outChannel:
+				ns.dropped.Add(1)
+			default:
+			}
+		}
+		return ErrSessionClosed
+	case OverflowCloseSlow:
+		ns.dropped.Add(1)
+		fmt.Printf("[%s] Session ID: %d slow consumer, write queue full \n", ns.GetRemoteAddr(), ns.ID)
+		ns.Stop()
+		return ErrSlowConsumer
+	}
+	if !block {
+		ns.dropped.Add(1)
+		return ErrQueueFull
+	}
+	var timeout <-chan time.Time
+	if ns.server.overflowPolicy == OverflowBlockTimeout {
+		timer := time.NewTimer(ns.server.sendTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ns.outChannel <- message:
+		ns.markEnqueued()
+		return nil
+	case <-ns.context.Done():
+		return ErrSessionClosed
+	case <-timeout:
+		ns.dropped.Add(1)
+		return ErrSendTimeout
+	}
+}
+
+// markEnqueued 记录消息成功加入队列，并更新队列深度的历史最大值
+func (ns *NormalSession) markEnqueued() {
+	ns.enqueued.Add(1)
+	depth := int64(len(ns.outChannel))
+	for {
+		high := ns.highWater.Load()
+		if depth <= high || ns.highWater.CompareAndSwap(high, depth) {
+			return
+		}
+	}
+}
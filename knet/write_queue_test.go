@@ -0,0 +1,149 @@
+// @Title write_queue_test.go
+// @Description 会话发送队列已满时各溢出策略的测试
+// @Author Zero - 2023/10/22 18:41:32
+
+package knet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newQueueTestSession 创建未启动写协程的会话，发送队列不会被消费，用于测试队列已满时的行为
+// 返回会话以及连接的客户端一端
+func newQueueTestSession(t *testing.T, opts ...NormalServerOption) (*NormalSession, net.Conn) {
+	t.Helper()
+	server := NewNormalServer(opts...).(*NormalServer)
+	t.Cleanup(server.pool.Release)
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { _ = peer.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	session := NewNormalSession(server, 1, conn, server.packer, ctx, cancel)
+	t.Cleanup(session.Stop)
+	return session, peer
+}
+
+// fillQueue 发送消息直至占满发送队列，消息ID依次为 1..容量
+func fillQueue(t *testing.T, session *NormalSession) {
+	t.Helper()
+	for i := 1; i <= cap(session.outChannel); i++ {
+		if err := session.Send(NewMessage(uint64(i), nil)); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+}
+
+// queuedIDs 取出发送队列中所有消息的ID
+func queuedIDs(session *NormalSession) []uint64 {
+	var ids []uint64
+	for len(session.outChannel) > 0 {
+		ids = append(ids, (<-session.outChannel).ID())
+	}
+	return ids
+}
+
+func TestOverflowBlock(t *testing.T) {
+	session, _ := newQueueTestSession(t, WithWriteQueue(2, OverflowBlock))
+	fillQueue(t, session)
+	if session.TrySend(NewMessage(3, nil)) {
+		t.Fatal("TrySend succeeded on a full queue")
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Send(NewMessage(3, nil))
+	}()
+	select {
+	case err := <-result:
+		t.Fatalf("Send returned %v on a full queue", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 会话关闭唤醒阻塞中的发送
+	session.Stop()
+	if err := <-result; !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("got %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestOverflowBlockTimeout(t *testing.T) {
+	session, _ := newQueueTestSession(t, WithWriteQueue(2, OverflowBlockTimeout), WithSendTimeout(50*time.Millisecond))
+	fillQueue(t, session)
+	start := time.Now()
+	if err := session.Send(NewMessage(3, nil)); !errors.Is(err, ErrSendTimeout) {
+		t.Fatalf("got %v, want ErrSendTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("timed out after %s, want at least 50ms", elapsed)
+	}
+	if stats := session.QueueStats(); stats.Dropped != 1 || stats.Enqueued != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	session, _ := newQueueTestSession(t, WithWriteQueue(2, OverflowDropNewest))
+	fillQueue(t, session)
+	if err := session.Send(NewMessage(3, nil)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+	if stats := session.QueueStats(); stats.Dropped != 1 || stats.HighWater != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if ids := queuedIDs(session); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("queued %v, want [1 2]", ids)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	session, _ := newQueueTestSession(t, WithWriteQueue(2, OverflowDropOldest))
+	fillQueue(t, session)
+	if err := session.Send(NewMessage(3, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := session.QueueStats(); stats.Dropped != 1 || stats.Enqueued != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if ids := queuedIDs(session); len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("queued %v, want [2 3]", ids)
+	}
+}
+
+func TestOverflowCloseSlow(t *testing.T) {
+	session, peer := newQueueTestSession(t, WithWriteQueue(2, OverflowCloseSlow))
+	fillQueue(t, session)
+	if err := session.Send(NewMessage(3, nil)); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("got %v, want ErrSlowConsumer", err)
+	}
+	if !session.IsClose() {
+		t.Fatal("slow consumer session not stopped")
+	}
+	// 会话关闭时连接随之关闭
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("peer read got %v, want io.EOF", err)
+	}
+	if err := session.Send(NewMessage(4, nil)); !errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("send after close got %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestSendTimeoutKeepsPolicy(t *testing.T) {
+	for _, opts := range [][]NormalServerOption{
+		{WithWriteQueue(2, OverflowDropOldest), WithSendTimeout(time.Second)},
+		{WithSendTimeout(time.Second), WithWriteQueue(2, OverflowDropOldest)},
+	} {
+		server := NewNormalServer(opts...).(*NormalServer)
+		server.pool.Release()
+		if server.overflowPolicy != OverflowDropOldest || server.sendTimeout != time.Second {
+			t.Fatalf("policy %s timeout %s, want drop-oldest 1s", server.overflowPolicy, server.sendTimeout)
+		}
+	}
+	server := NewNormalServer(WithSendTimeout(0)).(*NormalServer)
+	server.pool.Release()
+	if server.sendTimeout != defaultSendTimeout {
+		t.Fatalf("timeout %s, want default %s", server.sendTimeout, defaultSendTimeout)
+	}
+}